@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/akb/quoted/gdax"
+)
+
+// AddProductRequest is the body of POST /admin/products. Level is 2 or 3, and
+// 3 when it's left out
+type AddProductRequest struct {
+	ProductID string `json:"product_id"`
+	Level     int    `json:"level"`
+}
+
+// admin wraps a handler for an endpoint that changes what the server quotes.
+// They're only served when an admin token is configured, to requests that
+// give it as a bearer token
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		token := s.config.AdminToken
+		if len(token) == 0 {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		given := r.Header.Get("Authorization")
+		if !strings.HasPrefix(given, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(given[len("Bearer "):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// GET and POST /admin/products, and DELETE /admin/products/{id}
+//
+// Lists the products quoted, starts quoting another, or stops quoting one,
+// subscribing the feed to or unsubscribing it from the product as it runs.
+// A product added is only quoted once its book has loaded.
+func (s *Server) handleAdminProducts(w http.ResponseWriter, r *http.Request) {
+	productID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/admin/products"), "/")
+	switch {
+	case len(productID) == 0 && r.Method == http.MethodGet:
+		s.listProducts(w)
+	case len(productID) == 0 && r.Method == http.MethodPost:
+		s.addProduct(w, r)
+	case len(productID) > 0 && r.Method == http.MethodDelete:
+		s.removeProduct(w, productID)
+	case len(productID) == 0:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.Header().Set("Allow", "DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) listProducts(w http.ResponseWriter) {
+	connected := s.feed != nil && s.feed.Connected()
+	products := []ProductStatus{}
+	for _, orderbook := range s.books() {
+		products = append(products, productStatus(orderbook.Status(), connected))
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ProductID < products[j].ProductID
+	})
+
+	writeAdminResponse(w, http.StatusOK, products)
+}
+
+func (s *Server) addProduct(w http.ResponseWriter, r *http.Request) {
+	var request AddProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err))
+		return
+	}
+	if request.Level == 0 {
+		request.Level = 3
+	}
+	if request.Level != 2 && request.Level != 3 {
+		writeError(w, http.StatusBadRequest, "level must be 2 or 3")
+		return
+	}
+
+	s.changing.Lock()
+	defer s.changing.Unlock()
+
+	feed, ok := s.feed.(ProductFeed)
+	if !ok || s.newOrderBook == nil {
+		writeError(w, http.StatusConflict, "products can't be added to this feed")
+		return
+	}
+	if !s.registry.IsValidProductID(request.ProductID) {
+		writeError(w, http.StatusBadRequest,
+			fmt.Sprintf("%s is not a valid product id", request.ProductID))
+		return
+	}
+	if s.orderbook(request.ProductID) != nil {
+		writeError(w, http.StatusConflict,
+			fmt.Sprintf("%s is already quoted", request.ProductID))
+		return
+	}
+
+	// the feed subscribes to the product before the book loads its snapshot,
+	// so every message after the snapshot reaches the book. the ones before
+	// it that are missed are already in it
+	channel := gdax.FullChannel
+	if request.Level == 2 {
+		channel = gdax.Level2Channel
+	}
+	if err := feed.AddProduct(request.ProductID, channel); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	lob, err := s.newOrderBook(request.ProductID, request.Level)
+	if err != nil {
+		if err := feed.RemoveProduct(request.ProductID); err != nil {
+			fmt.Fprintf(os.Stderr, "Error unsubscribing from %s: %s\n", request.ProductID, err)
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.addLiveOrderBook(request.ProductID, lob)
+
+	writeAdminResponse(w, http.StatusCreated, productStatus(lob.Status(), feed.Connected()))
+}
+
+func (s *Server) removeProduct(w http.ResponseWriter, productID string) {
+	s.changing.Lock()
+	defer s.changing.Unlock()
+
+	orderbook := s.removeOrderBook(productID)
+	if orderbook == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s isn't quoted", productID))
+		return
+	}
+
+	// the book is gone whether or not the feed can stop sending its messages
+	if feed, ok := s.feed.(ProductFeed); ok {
+		if err := feed.RemoveProduct(productID); err != nil {
+			fmt.Fprintf(os.Stderr, "Error unsubscribing from %s: %s\n", productID, err)
+		}
+	}
+	if lob, ok := orderbook.(*gdax.LiveOrderBook); ok {
+		lob.Close()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminResponse(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
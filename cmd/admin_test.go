@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akb/quoted/gdax"
+	"github.com/akb/quoted/gdax/gdaxtest"
+)
+
+func adminRequest(
+	t *testing.T, ts *httptest.Server, method, path, body, token string,
+) (int, []byte) {
+	r, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer response.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(response.Body)
+	return response.StatusCode, buf.Bytes()
+}
+
+func TestAdminProducts(t *testing.T) {
+	fake := gdaxtest.NewServer(integrationBooks, integrationScript)
+	t.Cleanup(fake.Close)
+
+	t.Setenv("GDAX_API_URL", fake.URL)
+	t.Setenv("GDAX_WEBSOCKET_URL", fake.WebsocketURL())
+	t.Setenv("GDAX_PRODUCT_IDS", "BTC-USD")
+	t.Setenv("GDAX_ADMIN_TOKEN", "admin-token")
+	ts := startServer(t, map[string]int64{"BTC-USD": 105})
+
+	ltc := QuoteRequest{"buy", "LTC", "USD", "42.45", "LTC"}
+	if status, body := requestQuote(t, ts, ltc); status != http.StatusBadRequest {
+		t.Errorf("LTC-USD shouldn't be quoted yet, got %d: %s", status, body)
+	}
+
+	for _, c := range []struct {
+		method, path, body, token string
+		status                    int
+	}{
+		{"POST", "/admin/products", `{"product_id":"LTC-USD"}`, "", http.StatusUnauthorized},
+		{"POST", "/admin/products", `{"product_id":"LTC-USD"}`, "wrong", http.StatusUnauthorized},
+		{"POST", "/admin/products", `{"product_id":"XRP-USD"}`, "admin-token", http.StatusBadRequest},
+		{"POST", "/admin/products", `{"product_id":"LTC-USD","level":1}`, "admin-token", http.StatusBadRequest},
+		{"POST", "/admin/products", `{"product_id":"BTC-USD"}`, "admin-token", http.StatusConflict},
+		{"POST", "/admin/products", `{"product_id":"LTC-USD"}`, "admin-token", http.StatusCreated},
+		{"POST", "/admin/products", `{"product_id":"LTC-USD"}`, "admin-token", http.StatusConflict},
+		{"PUT", "/admin/products/LTC-USD", "", "admin-token", http.StatusMethodNotAllowed},
+	} {
+		if status, body := adminRequest(t, ts, c.method, c.path, c.body, c.token); status != c.status {
+			t.Errorf("%s %s %s: expected status %d, got %d: %s",
+				c.method, c.path, c.body, c.status, status, body)
+		}
+	}
+
+	// the book loads, and the feed sends it the script, as if it had been
+	// there from the start
+	waitForSequences(t, ts, map[string]int64{"BTC-USD": 105, "LTC-USD": 52})
+	status, body := requestQuote(t, ts, ltc)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+	var q QuoteResponse
+	if err := json.Unmarshal(body, &q); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if q.Price != "100.76" {
+		t.Errorf("expected a price of 100.76, got %s", q.Price)
+	}
+
+	// loading the book is its only reset, its first messages leave no gap
+	_, metrics := adminRequest(t, ts, "GET", "/metrics", "", "")
+	resets := `quoted_orderbook_resets_total{product_id="LTC-USD"} 1` + "\n"
+	if !strings.Contains(string(metrics), resets) {
+		t.Errorf("LTC-USD should have been reset once, got\n%s", metrics)
+	}
+
+	status, body = adminRequest(t, ts, "GET", "/admin/products", "", "admin-token")
+	var products []ProductStatus
+	if err := json.Unmarshal(body, &products); err != nil || status != http.StatusOK {
+		t.Fatalf("expected the products, got %d: %s", status, body)
+	}
+	if len(products) != 2 || products[1].ProductID != "LTC-USD" || products[1].Level != 3 {
+		t.Errorf("expected BTC-USD and LTC-USD, got %+v", products)
+	}
+
+	if status, body := adminRequest(t, ts, "DELETE", "/admin/products/LTC-USD", "", "admin-token"); status != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d: %s", status, body)
+	}
+	if status, body := adminRequest(t, ts, "DELETE", "/admin/products/LTC-USD", "", "admin-token"); status != http.StatusNotFound {
+		t.Errorf("removing a product twice should give 404, got %d: %s", status, body)
+	}
+	if status, body := requestQuote(t, ts, ltc); status != http.StatusBadRequest {
+		t.Errorf("LTC-USD shouldn't be quoted any more, got %d: %s", status, body)
+	}
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	ts := httptest.NewServer(NewServer(Config{}).Handler())
+	defer ts.Close()
+
+	if status, _ := adminRequest(t, ts, "GET", "/admin/products", "", ""); status != http.StatusNotFound {
+		t.Errorf("admin endpoints should not be served without a token, got %d", status)
+	}
+}
+
+// recordingFeed is a ProductFeed that records the products added and removed
+type recordingFeed struct {
+	fakeFeed
+	calls []string
+}
+
+func (f *recordingFeed) AddProduct(productID, channel string) error {
+	f.calls = append(f.calls, "add "+productID)
+	return nil
+}
+
+func (f *recordingFeed) RemoveProduct(productID string) error {
+	f.calls = append(f.calls, "remove "+productID)
+	return nil
+}
+
+func TestAdminAddProductSubscribesFirst(t *testing.T) {
+	s := NewServer(Config{AdminToken: "admin-token"})
+	s.SetRegistry(gdaxtest.NewRegistry("BTC-USD", "LTC-USD"))
+	feed := &recordingFeed{}
+	s.feed = feed
+	s.newOrderBook = func(productID string, level int) (*gdax.LiveOrderBook, error) {
+		// a snapshot loaded now is followed by every message after it
+		feed.calls = append(feed.calls, "book "+productID)
+		return nil, errors.New("snapshot unavailable")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/admin/products", strings.NewReader(`{"product_id":"LTC-USD"}`))
+	r.Header.Set("Authorization", "Bearer admin-token")
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d: %s", w.Code, w.Body)
+	}
+
+	// the feed is unsubscribed again when the book can't be made
+	expected := "add LTC-USD, book LTC-USD, remove LTC-USD"
+	if actual := strings.Join(feed.calls, ", "); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	if s.orderbook("LTC-USD") != nil {
+		t.Errorf("a book that couldn't be made shouldn't be quoted")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+)
+
+const (
+	defaultQuoteTTL       = 30 * time.Second
+	defaultStreamInterval = 250 * time.Millisecond
+	defaultFeedOverflow   = gdax.DropOnOverflow
+)
+
+// the products quoted unless GDAX_PRODUCT_IDS says otherwise
+var defaultProductIDs = []string{
+	"BTC-USD",
+	"ETH-USD", "ETH-BTC",
+	"LTC-USD", "LTC-BTC",
+}
+
+// Config contains the settings a Server is built from
+type Config struct {
+	ListenPort   string
+	APIURL       string
+	WebsocketURL string
+	GapTolerance int64
+
+	// how long quotes can be accepted for after they're issued
+	QuoteTTL time.Duration
+
+	// the least time between quotes sent on a stream
+	StreamInterval time.Duration
+
+	// the products to quote. when empty, every product that's online
+	ProductIDs []string
+
+	// products to keep level 2 books for, which only track the total size at
+	// each price. every other product gets a level 3 book
+	Level2ProductIDs []string
+
+	// how many feed messages each order book can fall behind by, and what
+	// happens when one falls further. when FeedOverflow is empty the feed's
+	// defaults apply
+	FeedBufferSize int
+	FeedOverflow   gdax.OverflowPolicy
+
+	// the fees charged on quotes. when nil, none are
+	Fees *FeeSchedule
+
+	// the API key requests to GDAX are signed with. when nil, only public
+	// endpoints are used
+	Credentials *gdax.Credentials
+
+	// the bearer token the admin endpoints require. when empty, they aren't
+	// served
+	AdminToken string
+
+	// when RecordFile is set, feed messages and snapshots are appended to it
+	RecordFile string
+
+	// when ReplayFile is set, order books are driven by the recording in it
+	// instead of by GDAX
+	ReplayFile    string
+	ReplayOptions gdax.ReplayOptions
+}
+
+// ConfigFromEnv reads configuration from environment variables, using
+// defaults for any that aren't set
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		ListenPort:   os.Getenv("GDAX_QUOTE_LISTEN_PORT"),
+		APIURL:       os.Getenv("GDAX_API_URL"),
+		WebsocketURL: os.Getenv("GDAX_WEBSOCKET_URL"),
+		AdminToken:   os.Getenv("GDAX_ADMIN_TOKEN"),
+		RecordFile:   os.Getenv("GDAX_RECORD_FILE"),
+		ReplayFile:   os.Getenv("GDAX_REPLAY_FILE"),
+	}
+
+	if len(config.ListenPort) == 0 {
+		config.ListenPort = "3000"
+	}
+
+	if len(config.APIURL) == 0 {
+		config.APIURL = "https://api.gdax.com"
+	}
+
+	if len(config.WebsocketURL) == 0 {
+		config.WebsocketURL = "wss://ws-feed.gdax.com"
+	}
+
+	// every online product is only quoted when asked for, since each one
+	// gets a book to load and keep up to date
+	switch s := os.Getenv("GDAX_PRODUCT_IDS"); s {
+	case "":
+		config.ProductIDs = defaultProductIDs
+	case "all":
+		config.ProductIDs = nil
+	default:
+		config.ProductIDs = splitProductIDs(s)
+	}
+	config.Level2ProductIDs = splitProductIDs(os.Getenv("GDAX_LEVEL2_PRODUCT_IDS"))
+
+	config.QuoteTTL = defaultQuoteTTL
+	if s := os.Getenv("GDAX_QUOTE_TTL"); len(s) > 0 {
+		var err error
+		config.QuoteTTL, err = time.ParseDuration(s)
+		if err != nil || config.QuoteTTL <= 0 {
+			return config, fmt.Errorf("Invalid GDAX_QUOTE_TTL: %s", s)
+		}
+	}
+
+	config.StreamInterval = defaultStreamInterval
+	if s := os.Getenv("GDAX_STREAM_INTERVAL"); len(s) > 0 {
+		var err error
+		config.StreamInterval, err = time.ParseDuration(s)
+		if err != nil || config.StreamInterval < 0 {
+			return config, fmt.Errorf("Invalid GDAX_STREAM_INTERVAL: %s", s)
+		}
+	}
+
+	config.FeedBufferSize = gdax.DefaultBufferSize
+	if s := os.Getenv("GDAX_FEED_BUFFER"); len(s) > 0 {
+		var err error
+		config.FeedBufferSize, err = strconv.Atoi(s)
+		if err != nil || config.FeedBufferSize < 1 {
+			return config, fmt.Errorf("Invalid GDAX_FEED_BUFFER: %s", s)
+		}
+	}
+
+	config.FeedOverflow = defaultFeedOverflow
+	if s := os.Getenv("GDAX_FEED_OVERFLOW"); len(s) > 0 {
+		var err error
+		config.FeedOverflow, err = gdax.ParseOverflowPolicy(s)
+		if err != nil {
+			return config, fmt.Errorf("Invalid GDAX_FEED_OVERFLOW: %s", s)
+		}
+	}
+
+	if s := os.Getenv("GDAX_FEE_SCHEDULE_FILE"); len(s) > 0 {
+		var err error
+		config.Fees, err = LoadFeeSchedule(s)
+		if err != nil {
+			return config, fmt.Errorf("Invalid GDAX_FEE_SCHEDULE_FILE: %s", err)
+		}
+	}
+
+	// credentials come from a file, or else from GDAX_API_KEY and friends
+	if s := os.Getenv("GDAX_CREDENTIALS_FILE"); len(s) > 0 {
+		var err error
+		config.Credentials, err = gdax.LoadCredentials(s)
+		if err != nil {
+			return config, fmt.Errorf("Invalid GDAX_CREDENTIALS_FILE: %s", err)
+		}
+	} else if key := os.Getenv("GDAX_API_KEY"); len(key) > 0 {
+		config.Credentials = &gdax.Credentials{
+			Key:        key,
+			Secret:     os.Getenv("GDAX_API_SECRET"),
+			Passphrase: os.Getenv("GDAX_API_PASSPHRASE"),
+		}
+		if err := config.Credentials.Validate(); err != nil {
+			return config, fmt.Errorf("Invalid GDAX_API_KEY: %s", err)
+		}
+	}
+
+	if s := os.Getenv("GDAX_GAP_TOLERANCE"); len(s) > 0 {
+		var err error
+		config.GapTolerance, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || config.GapTolerance < 0 {
+			return config, fmt.Errorf("Invalid GDAX_GAP_TOLERANCE: %s", s)
+		}
+	}
+
+	if s := os.Getenv("GDAX_REPLAY_REALTIME"); len(s) > 0 {
+		realTime, err := strconv.ParseBool(s)
+		if err != nil {
+			return config, fmt.Errorf("Invalid GDAX_REPLAY_REALTIME: %s", s)
+		}
+		config.ReplayOptions.RealTime = realTime
+	}
+
+	// GDAX_REPLAY_UNTIL looks like BTC-USD:123456
+	if s := os.Getenv("GDAX_REPLAY_UNTIL"); len(s) > 0 {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return config, fmt.Errorf("Invalid GDAX_REPLAY_UNTIL: %s", s)
+		}
+		sequence, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("Invalid GDAX_REPLAY_UNTIL: %s", s)
+		}
+		config.ReplayOptions.StopProductID = parts[0]
+		config.ReplayOptions.StopSequence = sequence
+	}
+
+	return config, nil
+}
+
+// splitProductIDs parses a comma separated list of product IDs
+func splitProductIDs(s string) []string {
+	var productIDs []string
+	for _, productID := range strings.Split(s, ",") {
+		if productID = strings.TrimSpace(productID); len(productID) > 0 {
+			productIDs = append(productIDs, productID)
+		}
+	}
+	return productIDs
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigProductIDs(t *testing.T) {
+	for env, expected := range map[string]string{
+		"":                  "BTC-USD,ETH-USD,ETH-BTC,LTC-USD,LTC-BTC",
+		"all":               "",
+		"BTC-USD, LTC-USD,": "BTC-USD,LTC-USD",
+	} {
+		t.Setenv("GDAX_PRODUCT_IDS", env)
+		config, err := ConfigFromEnv()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if actual := strings.Join(config.ProductIDs, ","); actual != expected {
+			t.Errorf("GDAX_PRODUCT_IDS=%q: expected %q, got %q", env, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/akb/quoted/gdax"
+)
+
+var (
+	one        = gdax.DecimalFromInt(1)
+	basisPoint = gdax.DecimalFromInt(10000)
+)
+
+// FeeSchedule is what quotes are charged on top of the order book price: the
+// exchange's taker fee on each product traded, and a house markup on the whole
+// quote. It's read from a JSON file such as
+//
+//	{
+//	  "markup_bps": "10",
+//	  "volume": "2500000",
+//	  "tiers": [
+//	    {"min_volume": "0", "taker_fee_rate": "0.0025"},
+//	    {"min_volume": "1000000", "taker_fee_rate": "0.0020"}
+//	  ],
+//	  "products": {
+//	    "ETH-BTC": [{"min_volume": "0", "taker_fee_rate": "0.0030"}]
+//	  }
+//	}
+//
+// Taker fee rates are fractions of the amount traded, the way GDAX gives them.
+// The rate charged is that of the tier with the highest MinVolume no greater
+// than Volume, our trailing 30 day volume, from the product's own tiers if it
+// has any. The zero value charges nothing.
+type FeeSchedule struct {
+	MarkupBps gdax.Decimal         `json:"markup_bps"`
+	Volume    gdax.Decimal         `json:"volume"`
+	Tiers     []FeeTier            `json:"tiers"`
+	Products  map[string][]FeeTier `json:"products"`
+}
+
+// FeeTier is the taker fee rate for a volume tier
+type FeeTier struct {
+	MinVolume    gdax.Decimal `json:"min_volume"`
+	TakerFeeRate gdax.Decimal `json:"taker_fee_rate"`
+}
+
+// LoadFeeSchedule reads a fee schedule from a JSON file
+func LoadFeeSchedule(path string) (*FeeSchedule, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f FeeSchedule
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, err
+	}
+
+	if f.MarkupBps.Sign() < 0 || f.MarkupBps.Cmp(basisPoint) >= 0 {
+		return nil, fmt.Errorf("markup_bps must be from 0 up to 10000")
+	}
+	tiers := append([]FeeTier{}, f.Tiers...)
+	for _, t := range f.Products {
+		tiers = append(tiers, t...)
+	}
+	for _, t := range tiers {
+		if t.TakerFeeRate.Sign() < 0 || t.TakerFeeRate.Cmp(one) >= 0 {
+			return nil, fmt.Errorf("taker_fee_rate must be from 0 up to 1")
+		}
+	}
+
+	return &f, nil
+}
+
+// TakerFeeRate returns the taker fee rate charged on a product
+func (f *FeeSchedule) TakerFeeRate(productID string) gdax.Decimal {
+	tiers, ok := f.Products[productID]
+	if !ok {
+		tiers = f.Tiers
+	}
+
+	var rate, minVolume gdax.Decimal
+	found := false
+	for _, t := range tiers {
+		if t.MinVolume.Cmp(f.Volume) <= 0 && (!found || t.MinVolume.Cmp(minVolume) > 0) {
+			rate, minVolume, found = t.TakerFeeRate, t.MinVolume, true
+		}
+	}
+	return rate
+}
+
+// rate returns the fraction of a quote along a route charged in fees. Each leg
+// is a separate trade, so its taker fee is charged on what's left after the
+// fees of the legs before it
+func (f *FeeSchedule) rate(r route) gdax.Decimal {
+	kept := one
+	for _, l := range r {
+		kept = kept.Mul(one.Sub(f.TakerFeeRate(l.product.ID)))
+	}
+	return one.Sub(kept).Add(f.MarkupBps.Div(basisPoint))
+}
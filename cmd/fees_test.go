@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+	"github.com/akb/quoted/gdax/gdaxtest"
+)
+
+// decimal parses a decimal literal, panicking if it's malformed
+func decimal(s string) gdax.Decimal {
+	v, err := gdax.NewDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestTakerFeeRate(t *testing.T) {
+	fees := &FeeSchedule{
+		Volume: gdax.DecimalFromInt(2500000),
+		Tiers: []FeeTier{
+			{gdax.DecimalFromInt(1000000), decimal("0.0020")},
+			{gdax.DecimalFromInt(0), decimal("0.0025")},
+			{gdax.DecimalFromInt(5000000), decimal("0.0015")},
+		},
+		Products: map[string][]FeeTier{
+			"ETH-BTC": {{gdax.DecimalFromInt(0), decimal("0.0030")}},
+		},
+	}
+
+	for productID, expected := range map[string]string{
+		"BTC-USD": "0.002",
+		"ETH-BTC": "0.003",
+	} {
+		if rate := fees.TakerFeeRate(productID); rate.Cmp(decimal(expected)) != 0 {
+			t.Errorf("%s: expected a rate of %s, got %s", productID, expected, rate)
+		}
+	}
+
+	if rate := (&FeeSchedule{}).TakerFeeRate("BTC-USD"); !rate.IsZero() {
+		t.Errorf("an empty schedule shouldn't charge fees, got %s", rate)
+	}
+}
+
+func TestLoadFeeSchedule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fees")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		json  string
+		valid bool
+	}{
+		{`{"markup_bps":"5","tiers":[{"min_volume":"0","taker_fee_rate":"0.0025"}]}`, true},
+		{`{"markup_bps":"-5"}`, false},
+		{`{"products":{"BTC-USD":[{"min_volume":"0","taker_fee_rate":"1"}]}}`, false},
+		{`{"tiers":`, false},
+	} {
+		path := filepath.Join(dir, "fees.json")
+		if err := ioutil.WriteFile(path, []byte(c.json), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		_, err := LoadFeeSchedule(path)
+		if c.valid && err != nil {
+			t.Errorf("%s: %s", c.json, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected an error", c.json)
+		}
+	}
+}
+
+func TestQuoteFees(t *testing.T) {
+	// 0.25% taker fee and 5 bps markup, 0.3% in all
+	fees := &FeeSchedule{
+		MarkupBps: gdax.DecimalFromInt(5),
+		Tiers:     []FeeTier{{gdax.DecimalFromInt(0), decimal("0.0025")}},
+	}
+
+	for _, c := range []struct {
+		body     string
+		amount   string
+		expected QuoteResponse
+	}{
+		// buy 2 LTC, paying fees on top
+		{`{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"2"}`, "2",
+			QuoteResponse{"50.00", "100.00", "100.00", "0.30", "100.30", "USD",
+				"2.00000000", "LTC", nil}},
+		// sell 2 LTC, receiving less fees
+		{`{"action":"sell","base_currency":"LTC","quote_currency":"USD","amount":"2"}`, "2",
+			QuoteResponse{"50.00", "100.00", "100.00", "0.30", "99.70", "USD",
+				"2.00000000", "LTC", nil}},
+		// buy LTC with exactly 100.30 USD, of which 100 is spent on the book
+		{`{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"100.30","amount_currency":"USD"}`,
+			"100",
+			QuoteResponse{"50.00", "100.00", "100.00", "0.30", "100.30", "USD",
+				"2.00000000", "LTC", nil}},
+		// sell LTC for exactly 99.70 USD, which takes 100 from the book
+		{`{"action":"sell","base_currency":"LTC","quote_currency":"USD","amount":"99.70","amount_currency":"USD"}`,
+			"100",
+			QuoteResponse{"50.00", "100.00", "100.00", "0.30", "99.70", "USD",
+				"2.00000000", "LTC", nil}},
+	} {
+		book := &fakeQuoter{size: "2", funds: "100", running: true}
+		s := NewServer(Config{QuoteTTL: time.Minute, Fees: fees})
+		s.SetRegistry(gdaxtest.NewRegistry("LTC-USD"))
+		s.AddOrderBook("LTC-USD", book)
+
+		w := postQuote(s, c.body)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", c.body, w.Code, w.Body)
+			continue
+		}
+
+		var q QuoteResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+			t.Fatalf("%s", err)
+		}
+		q.Legs = nil
+		if !reflect.DeepEqual(q, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.body, c.expected, q)
+		}
+		if book.amount.Cmp(decimal(c.amount)) != 0 {
+			t.Errorf("%s: expected the book to be quoted for %s, got %s",
+				c.body, c.amount, book.amount)
+		}
+	}
+}
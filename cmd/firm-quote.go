@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long quotes are remembered after they expire, so that an accept that
+// arrives just too late is told the quote expired rather than not found.
+// kept short, since every quote issued is held until then
+const quoteRetention = time.Minute
+
+var (
+	ErrQuoteNotFound = errors.New("quote not found")
+	ErrQuoteExpired  = errors.New("quote has expired")
+	ErrQuoteAccepted = errors.New("quote has already been accepted")
+)
+
+// FirmQuote is a quote that has been given an ID and is honored until it
+// expires. It's returned from POST /quote, GET /quote/{id} and
+// POST /quote/{id}/accept. Request is the request it was made for, and
+// Sequences holds the sequence of each order book it was walked through.
+type FirmQuote struct {
+	ID      string       `json:"id"`
+	Request QuoteRequest `json:"request"`
+	QuoteResponse
+	Sequences  map[string]int64 `json:"sequences"`
+	CreatedAt  time.Time        `json:"created_at"`
+	ExpiresAt  time.Time        `json:"expires_at"`
+	AcceptedAt *time.Time       `json:"accepted_at,omitempty"`
+}
+
+// QuoteStore keeps the quotes a server has issued. Accept must atomically
+// check that the quote hasn't expired or been accepted and mark it accepted.
+// Servers keep quotes in memory unless another store is given to
+// SetQuoteStore
+type QuoteStore interface {
+	Put(q FirmQuote) error
+	Get(id string) (FirmQuote, error)
+	Accept(id string, at time.Time) (FirmQuote, error)
+}
+
+// memoryQuoteStore is a QuoteStore that forgets quotes quoteRetention after
+// they expire
+type memoryQuoteStore struct {
+	*sync.Mutex
+	quotes map[string]FirmQuote
+
+	// quote IDs in the order they were put, which is the order they expire in
+	// as long as the TTL doesn't change
+	order []string
+}
+
+func newMemoryQuoteStore() *memoryQuoteStore {
+	return &memoryQuoteStore{
+		Mutex:  &sync.Mutex{},
+		quotes: map[string]FirmQuote{},
+	}
+}
+
+func (m *memoryQuoteStore) Put(q FirmQuote) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for len(m.order) > 0 {
+		old, ok := m.quotes[m.order[0]]
+		if ok && q.CreatedAt.Before(old.ExpiresAt.Add(quoteRetention)) {
+			break
+		}
+		delete(m.quotes, m.order[0])
+		m.order = m.order[1:]
+	}
+
+	m.quotes[q.ID] = q
+	m.order = append(m.order, q.ID)
+	return nil
+}
+
+func (m *memoryQuoteStore) Get(id string) (FirmQuote, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	q, ok := m.quotes[id]
+	if !ok {
+		return FirmQuote{}, ErrQuoteNotFound
+	}
+	return q, nil
+}
+
+func (m *memoryQuoteStore) Accept(id string, at time.Time) (FirmQuote, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	q, ok := m.quotes[id]
+	switch {
+	case !ok:
+		return FirmQuote{}, ErrQuoteNotFound
+	case q.AcceptedAt != nil:
+		return q, ErrQuoteAccepted
+	case !at.Before(q.ExpiresAt):
+		return q, ErrQuoteExpired
+	}
+
+	q.AcceptedAt = &at
+	m.quotes[id] = q
+	return q, nil
+}
+
+// GET /quote/{id} and POST /quote/{id}/accept
+func (s *Server) handleFirmQuote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/quote/"), "/")
+	var q FirmQuote
+	var err error
+	switch {
+	case len(parts) == 1 && len(parts[0]) > 0:
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, err = s.quotes.Get(parts[0])
+
+	case len(parts) == 2 && len(parts[0]) > 0 && parts[1] == "accept":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, err = s.quotes.Accept(parts[0], s.now())
+
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrQuoteNotFound:
+		writeError(w, http.StatusNotFound, err)
+		return
+	case ErrQuoteExpired:
+		writeError(w, http.StatusGone, err)
+		return
+	case ErrQuoteAccepted:
+		writeError(w, http.StatusConflict, err)
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	body, err := json.Marshal(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func firmQuoteRequest(s *Server, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestFirmQuote(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestServer()
+	s.now = func() time.Time { return now }
+	s.AddOrderBook("LTC-USD", &fakeQuoter{size: "2", funds: "100.02", running: true})
+
+	issue := func() FirmQuote {
+		w := postQuote(s, `{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"2"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+		var q FirmQuote
+		if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+			t.Fatalf("%s", err)
+		}
+		return q
+	}
+
+	issued := issue()
+	if len(issued.ID) == 0 || !issued.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected an ID and expiry a minute from now, got %+v", issued)
+	}
+	if issued.Request.Amount != "2" || issued.Price != "50.01" {
+		t.Errorf("the quote should include its request and price, got %+v", issued)
+	}
+
+	w := firmQuoteRequest(s, http.MethodGet, "/quote/"+issued.ID)
+	var fetched FirmQuote
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &fetched) != nil {
+		t.Fatalf("expected the quote to be fetched, got %d: %s", w.Code, w.Body)
+	}
+	if fetched.ID != issued.ID || fetched.Total != issued.Total {
+		t.Errorf("expected %+v, got %+v", issued, fetched)
+	}
+
+	now = now.Add(30 * time.Second)
+	w = firmQuoteRequest(s, http.MethodPost, "/quote/"+issued.ID+"/accept")
+	var accepted FirmQuote
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &accepted) != nil {
+		t.Fatalf("expected the quote to be accepted, got %d: %s", w.Code, w.Body)
+	}
+	if accepted.AcceptedAt == nil || !accepted.AcceptedAt.Equal(now) {
+		t.Errorf("expected the quote to be accepted at %s, got %v", now, accepted.AcceptedAt)
+	}
+
+	expired := issue()
+	now = now.Add(time.Minute)
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodPost, "/quote/" + issued.ID + "/accept", http.StatusConflict},
+		{http.MethodPost, "/quote/" + expired.ID + "/accept", http.StatusGone},
+		{http.MethodGet, "/quote/" + expired.ID, http.StatusOK},
+		{http.MethodGet, "/quote/unknown", http.StatusNotFound},
+		{http.MethodPost, "/quote/unknown/accept", http.StatusNotFound},
+		{http.MethodGet, "/quote/" + issued.ID + "/accept", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/quote/" + issued.ID, http.StatusMethodNotAllowed},
+	} {
+		if w := firmQuoteRequest(s, c.method, c.path); w.Code != c.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s",
+				c.method, c.path, c.status, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMemoryQuoteStoreForgets(t *testing.T) {
+	store := newMemoryQuoteStore()
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store.Put(FirmQuote{ID: "old", CreatedAt: start, ExpiresAt: start.Add(time.Minute)})
+
+	// an expired quote is still known until it's been retained
+	sooner := start.Add(time.Minute + quoteRetention/2)
+	store.Put(FirmQuote{ID: "sooner", CreatedAt: sooner, ExpiresAt: sooner.Add(time.Minute)})
+	if _, err := store.Accept("old", sooner); err != ErrQuoteExpired {
+		t.Errorf("expected the quote to have expired, got %v", err)
+	}
+
+	later := start.Add(time.Minute + quoteRetention)
+	store.Put(FirmQuote{ID: "new", CreatedAt: later, ExpiresAt: later.Add(time.Minute)})
+
+	if _, err := store.Get("old"); err != ErrQuoteNotFound {
+		t.Errorf("quotes should be forgotten after they're retained, got %v", err)
+	}
+	if _, err := store.Get("new"); err != nil {
+		t.Errorf("%s", err)
+	}
+}
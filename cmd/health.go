@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+)
+
+// ProductStatus reports how far along a product's order book is in
+// synchronizing with GDAX. A list of them is returned from GET /readyz and
+// GET /admin/products
+type ProductStatus struct {
+	ProductID       string     `json:"product_id"`
+	Level           int        `json:"level"`
+	State           string     `json:"state"`
+	Sequence        int64      `json:"sequence"`
+	LastMessageTime *time.Time `json:"last_message_time"`
+	DroppedMessages int64      `json:"dropped_messages"`
+	FeedConnected   bool       `json:"feed_connected"`
+
+	// how many feed messages are waiting for the book, and how many it has
+	// missed for falling too far behind
+	FeedLag     int   `json:"feed_lag"`
+	FeedDropped int64 `json:"feed_dropped"`
+}
+
+// ReadinessResponse is returned from GET /readyz
+type ReadinessResponse struct {
+	Ready    bool            `json:"ready"`
+	Products []ProductStatus `json:"products"`
+}
+
+// GET /healthz
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// GET /readyz
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	connected := s.feed != nil && s.feed.Connected()
+	response := ReadinessResponse{Ready: true}
+	for _, orderbook := range s.books() {
+		lobStatus := orderbook.Status()
+		response.Products = append(response.Products, productStatus(lobStatus, connected))
+		response.Ready = response.Ready && lobStatus.Running
+	}
+	sort.Slice(response.Products, func(i, j int) bool {
+		return response.Products[i].ProductID < response.Products[j].ProductID
+	})
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if response.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(body)
+}
+
+// productStatus describes a book's synchronization with the feed
+func productStatus(lobStatus gdax.LiveOrderBookStatus, connected bool) ProductStatus {
+	status := ProductStatus{
+		ProductID:       lobStatus.ProductID,
+		Level:           lobStatus.Level,
+		State:           lobStatus.State,
+		Sequence:        lobStatus.Sequence,
+		DroppedMessages: lobStatus.DroppedMessages,
+		FeedConnected:   connected,
+		FeedLag:         lobStatus.Feed.Lag,
+		FeedDropped:     lobStatus.Feed.Dropped,
+	}
+	if !lobStatus.LastMessageTime.IsZero() {
+		status.LastMessageTime = &lobStatus.LastMessageTime
+	}
+	return status
+}
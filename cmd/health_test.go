@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	s := NewServer(Config{})
+	running := &fakeQuoter{running: true}
+	loading := &fakeQuoter{running: false}
+	s.AddOrderBook("BTC-USD", running)
+	s.AddOrderBook("LTC-USD", loading)
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	if status := get(); status != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 while a book is loading, got %d", status)
+	}
+
+	loading.running = true
+	if status := get(); status != http.StatusOK {
+		t.Errorf("expected status 200 once every book is running, got %d", status)
+	}
+}
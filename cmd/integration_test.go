@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+	"github.com/akb/quoted/gdax/gdaxtest"
+)
+
+// snapshots served by the fake GDAX. the script below is applied on top of
+// them, the totals in the test cases are for the resulting books
+var integrationBooks = map[string]gdaxtest.Book{
+	"BTC-USD": {
+		Sequence: 100,
+		Bids: []gdaxtest.Order{
+			{"10000.00", "1.5", "btc-bid-1"},
+			{"9999.50", "2", "btc-bid-2"},
+			{"9990.00", "5", "btc-bid-3"},
+		},
+		Asks: []gdaxtest.Order{
+			{"10001.00", "10", "btc-ask-1"},
+			{"10002.50", "5", "btc-ask-2"},
+			{"10010.00", "20", "btc-ask-3"},
+		},
+	},
+	"ETH-USD": {
+		Sequence: 10,
+		Bids:     []gdaxtest.Order{{"500.00", "100", "eth-usd-bid-1"}},
+		Asks:     []gdaxtest.Order{{"500.50", "100", "eth-usd-ask-1"}},
+	},
+	"ETH-BTC": {
+		Sequence: 200,
+		Bids: []gdaxtest.Order{
+			{"0.05000", "100", "eth-bid-1"},
+			{"0.04990", "150", "eth-bid-2"},
+		},
+		Asks: []gdaxtest.Order{
+			{"0.05010", "80", "eth-ask-1"},
+			{"0.05020", "200", "eth-ask-2"},
+		},
+	},
+	"LTC-USD": {
+		Sequence: 50,
+		Bids: []gdaxtest.Order{
+			{"100.00", "20", "ltc-bid-1"},
+			{"99.50", "30", "ltc-bid-2"},
+		},
+		Asks: []gdaxtest.Order{
+			{"100.50", "25", "ltc-ask-1"},
+			{"101.00", "40", "ltc-ask-2"},
+		},
+	},
+	"LTC-BTC": {
+		Sequence: 20,
+		Bids:     []gdaxtest.Order{{"0.01000", "100", "ltc-btc-bid-1"}},
+		Asks:     []gdaxtest.Order{{"0.01010", "100", "ltc-btc-ask-1"}},
+	},
+}
+
+var integrationScript = []gdaxtest.Message{
+	// older than the snapshot, must be ignored
+	{Type: gdax.OpenMessage, Sequence: 99, ProductID: "BTC-USD",
+		OrderID: "stale", Side: gdax.AskSide, Price: "1.00", RemainingSize: "1000"},
+
+	{Type: gdax.OpenMessage, Sequence: 101, ProductID: "BTC-USD",
+		OrderID: "btc-ask-4", Side: gdax.AskSide, Price: "10001.50", RemainingSize: "3"},
+	{Type: gdax.OpenMessage, Sequence: 51, ProductID: "LTC-USD",
+		OrderID: "ltc-bid-3", Side: gdax.BidSide, Price: "100.25", RemainingSize: "10"},
+	{Type: gdax.MatchMessage, Sequence: 102, ProductID: "BTC-USD",
+		MakerOrderID: "btc-ask-1", TakerOrderID: "taker", Side: gdax.AskSide,
+		Price: "10001.00", Size: "4"},
+	{Type: gdax.ChangeMessage, Sequence: 201, ProductID: "ETH-BTC",
+		OrderID: "eth-ask-1", Side: gdax.AskSide, Price: "0.05010",
+		OldSize: "80", NewSize: "60"},
+	{Type: gdax.ChangeMessage, Sequence: 103, ProductID: "BTC-USD",
+		OrderID: "btc-ask-2", Side: gdax.AskSide, Price: "10002.50",
+		OldSize: "5", NewSize: "2"},
+	{Type: gdax.MatchMessage, Sequence: 52, ProductID: "LTC-USD",
+		MakerOrderID: "ltc-ask-1", TakerOrderID: "taker", Side: gdax.AskSide,
+		Price: "100.50", Size: "5"},
+	{Type: gdax.DoneMessage, Sequence: 104, ProductID: "BTC-USD",
+		OrderID: "btc-bid-1", Side: gdax.BidSide, Reason: "canceled"},
+	// an order that was filled without resting on the book
+	{Type: gdax.DoneMessage, Sequence: 105, ProductID: "BTC-USD",
+		OrderID: "never-opened", Side: gdax.BidSide, Reason: "filled"},
+}
+
+// the sequence each book should reach once the script has been applied
+var integrationSequences = map[string]int64{
+	"BTC-USD": 105, "ETH-USD": 10, "ETH-BTC": 201, "LTC-USD": 52, "LTC-BTC": 20,
+}
+
+func startIntegrationServer(t *testing.T) *httptest.Server {
+	fake := gdaxtest.NewServer(integrationBooks, integrationScript)
+	t.Cleanup(fake.Close)
+
+	t.Setenv("GDAX_API_URL", fake.URL)
+	t.Setenv("GDAX_WEBSOCKET_URL", fake.WebsocketURL())
+
+	return startServer(t, integrationSequences)
+}
+
+// startServer starts a server configured from the environment and waits for
+// its books to reach the given sequences
+func startServer(t *testing.T, sequences map[string]int64) *httptest.Server {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	server := NewServer(config)
+	if err := server.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+
+	waitForSequences(t, ts, sequences)
+	return ts
+}
+
+// waitForSequences polls /readyz until every book is running and has applied
+// the feed up to the expected sequence
+func waitForSequences(t *testing.T, ts *httptest.Server, sequences map[string]int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		response, err := http.Get(ts.URL + "/readyz")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		var readiness ReadinessResponse
+		err = json.NewDecoder(response.Body).Decode(&readiness)
+		response.Body.Close()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		caughtUp := readiness.Ready
+		for _, p := range readiness.Products {
+			caughtUp = caughtUp && p.Sequence == sequences[p.ProductID]
+		}
+		if caughtUp {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for order books to synchronize")
+}
+
+func requestQuote(t *testing.T, ts *httptest.Server, q QuoteRequest) (int, []byte) {
+	body, _ := json.Marshal(q)
+	response, err := http.Post(ts.URL+"/quote", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer response.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(response.Body)
+	return response.StatusCode, buf.Bytes()
+}
+
+func TestIntegration(t *testing.T) {
+	ts := startIntegrationServer(t)
+
+	t.Run("happy", func(t *testing.T) {
+		for _, c := range []struct {
+			request  QuoteRequest
+			expected QuoteResponse
+		}{
+			// buy 42.45 LTC with USD
+			{QuoteRequest{"buy", "LTC", "USD", "42.45", "LTC"},
+				QuoteResponse{"100.76", "4277.26", "4277.26", "0.00", "4277.26", "USD",
+					"42.45000000", "LTC", nil}},
+			// sell 42.45 LTC for USD
+			{QuoteRequest{"sell", "LTC", "USD", "42.45", "LTC"},
+				QuoteResponse{"99.91", "4241.18", "4241.18", "0.00", "4241.18", "USD",
+					"42.45000000", "LTC", nil}},
+			// buy 20.35 BTC with USD
+			{QuoteRequest{"buy", "BTC", "USD", "20.35", "BTC"},
+				QuoteResponse{"10005.36", "203609.08", "203609.08", "0.00", "203609.08", "USD",
+					"20.35000000", "BTC", nil}},
+			// buy 10 BTC with ETH
+			{QuoteRequest{"buy", "BTC", "ETH", "10", "BTC"},
+				QuoteResponse{"20.02004008", "200.20040080", "200.20040080", "0.00000000", "200.20040080", "ETH",
+					"10.00000000", "BTC", nil}},
+			// buy 10 ETH with BTC
+			{QuoteRequest{"buy", "ETH", "BTC", "10", "ETH"},
+				QuoteResponse{"0.05010000", "0.50100000", "0.50100000", "0.00000000", "0.50100000", "BTC",
+					"10.00000000", "ETH", nil}},
+			// buy 100 USD with BTC
+			{QuoteRequest{"buy", "USD", "BTC", "100", "USD"},
+				QuoteResponse{"0.00010001", "0.01000100", "0.01000100", "0.00000000", "0.01000100", "BTC",
+					"100.00", "USD", nil}},
+			// buy BTC with exactly 250 USD
+			{QuoteRequest{"buy", "BTC", "USD", "250", "USD"},
+				QuoteResponse{"10001.00", "250.00", "250.00", "0.00", "250.00", "USD",
+					"0.02499750", "BTC", nil}},
+			// sell LTC for exactly 1000 USD
+			{QuoteRequest{"sell", "LTC", "USD", "1000", "USD"},
+				QuoteResponse{"100.25", "1000.00", "1000.00", "0.00", "1000.00", "USD",
+					"9.97506234", "LTC", nil}},
+			// buy 10 ETH with LTC, through BTC which is cheaper than USD
+			{QuoteRequest{"buy", "ETH", "LTC", "10", "ETH"},
+				QuoteResponse{"5.01000000", "50.10000000", "50.10000000", "0.00000000", "50.10000000", "LTC",
+					"10.00000000", "ETH",
+					[]QuoteLeg{
+						{"LTC-BTC", "sell", "0.01000000", "50.10000000", "0.50100000", nil},
+						{"ETH-BTC", "buy", "0.05010000", "10.00000000", "0.50100000", nil},
+					}}},
+			// sell 100 LTC for ETH, through BTC since the USD books are too
+			// shallow
+			{QuoteRequest{"sell", "LTC", "ETH", "100", "LTC"},
+				QuoteResponse{"0.19960080", "19.96008000", "19.96008000", "0.00000000", "19.96008000", "ETH",
+					"100.00000000", "LTC",
+					[]QuoteLeg{
+						{"LTC-BTC", "sell", "0.01000000", "100.00000000", "1.00000000", nil},
+						{"ETH-BTC", "buy", "0.05010000", "19.96007984", "1.00000000", nil},
+					}}},
+		} {
+			status, body := requestQuote(t, ts, c.request)
+			if status != http.StatusOK {
+				t.Errorf("%+v: expected status 200, got %d: %s", c.request, status, body)
+				continue
+			}
+
+			var q QuoteResponse
+			if err := json.Unmarshal(body, &q); err != nil {
+				t.Errorf("%+v: %s", c.request, err)
+				continue
+			}
+			// legs are only checked for routed quotes
+			if c.expected.Legs == nil {
+				q.Legs = nil
+			}
+			if !reflect.DeepEqual(q, c.expected) {
+				t.Errorf("%+v: expected %+v, got %+v", c.request, c.expected, q)
+			}
+		}
+	})
+
+	t.Run("sad", func(t *testing.T) {
+		for _, request := range []QuoteRequest{
+			// buy 100 LTC with GBP
+			{"buy", "LTC", "GBP", "100", "LTC"},
+			// buy 100 ARK with USD
+			{"buy", "ARK", "USD", "100", "ARK"},
+			// sell 100 USD for Ark
+			{"sell", "USD", "Ark", "100", "USD"},
+			// waffle 100 BTC with USD
+			{"waffle", "BTC", "USD", "100", "BTC"},
+			// buy -100 BTC with USD
+			{"buy", "BTC", "USD", "-100", "BTC"},
+			// sell 25,000,000 BTC for USD
+			{"sell", "BTC", "USD", "25000000", "BTC"},
+			// buy BTC with 1,000,000,000 USD
+			{"buy", "BTC", "USD", "1000000000", "USD"},
+			// buy 100 of LTC, which is neither currency, worth of BTC
+			{"buy", "BTC", "USD", "100", "LTC"},
+		} {
+			status, body := requestQuote(t, ts, request)
+			if status == http.StatusOK {
+				t.Errorf("%+v: bad request responded with success status", request)
+			}
+
+			var e struct{ Message string }
+			if err := json.Unmarshal(body, &e); err != nil || len(e.Message) == 0 {
+				t.Errorf("%+v: bad request did not respond with error message", request)
+			}
+		}
+	})
+
+	t.Run("detail", func(t *testing.T) {
+		body, _ := json.Marshal(QuoteRequest{"buy", "BTC", "USD", "8", "BTC"})
+		response, err := http.Post(ts.URL+"/quote?detail=true", "application/json",
+			bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer response.Body.Close()
+
+		var q QuoteResponse
+		if err := json.NewDecoder(response.Body).Decode(&q); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(q.Legs) != 1 || q.Legs[0].Detail == nil {
+			t.Fatalf("expected a leg with detail, got %+v", q)
+		}
+
+		detail := *q.Legs[0].Detail
+		if detail.Time.IsZero() {
+			t.Errorf("detail should include the time it was quoted")
+		}
+		detail.Time = time.Time{}
+
+		// 6 @ 10001.00 and 2 @ 10001.50, an average of 10001.125
+		expected := QuoteDetail{
+			BestBid:        "9999.50",
+			BestAsk:        "10001.00",
+			MidPrice:       "10000.250",
+			WorstPrice:     "10001.50",
+			LevelsConsumed: 2,
+			SlippageBps:    "0.12",
+			Sequence:       105,
+			Fills: []QuoteFill{
+				{"10001.00", "6.00000000"},
+				{"10001.50", "2.00000000"},
+			},
+		}
+		if !reflect.DeepEqual(detail, expected) {
+			t.Errorf("expected %+v, got %+v", expected, detail)
+		}
+	})
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	t.Setenv("GDAX_RECORD_FILE", path)
+	startIntegrationServer(t)
+
+	// replay the recording with GDAX pointed nowhere
+	t.Setenv("GDAX_RECORD_FILE", "")
+	t.Setenv("GDAX_REPLAY_FILE", path)
+	t.Setenv("GDAX_API_URL", "http://localhost:1")
+	t.Setenv("GDAX_WEBSOCKET_URL", "ws://localhost:1")
+	ts := startServer(t, integrationSequences)
+
+	status, body := requestQuote(t, ts, QuoteRequest{"buy", "BTC", "USD", "20.35", "BTC"})
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+
+	var q QuoteResponse
+	if err := json.Unmarshal(body, &q); err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := QuoteResponse{"10005.36", "203609.08", "203609.08", "0.00", "203609.08", "USD",
+		"20.35000000", "BTC",
+		[]QuoteLeg{{"BTC-USD", "buy", "10005.36", "20.35000000", "203609.00", nil}}}
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("replayed book should quote %+v, got %+v", expected, q)
+	}
+}
+
+func TestLevel2(t *testing.T) {
+	// level 2 messages are only sent to subscribers of the level2 channel, so
+	// the level 3 script for LTC-USD is never seen
+	script := append([]gdaxtest.Message{
+		{Type: gdax.SnapshotMessage, ProductID: "LTC-USD",
+			Bids: [][]string{{"100.00", "20"}, {"99.50", "30"}},
+			Asks: [][]string{{"100.50", "25"}, {"101.00", "40"}}},
+		{Type: gdax.L2UpdateMessage, ProductID: "LTC-USD",
+			Changes: [][]string{{gdax.AskSide, "100.50", "20"}, {gdax.AskSide, "100.75", "5"}}},
+	}, integrationScript...)
+
+	fake := gdaxtest.NewServer(integrationBooks, script)
+	t.Cleanup(fake.Close)
+
+	t.Setenv("GDAX_API_URL", fake.URL)
+	t.Setenv("GDAX_WEBSOCKET_URL", fake.WebsocketURL())
+	t.Setenv("GDAX_PRODUCT_IDS", "BTC-USD,LTC-USD")
+	t.Setenv("GDAX_LEVEL2_PRODUCT_IDS", "LTC-USD")
+
+	// level 2 messages aren't sequenced, so the book stays at its snapshot's
+	ts := startServer(t, map[string]int64{"BTC-USD": 105, "LTC-USD": 50})
+
+	for _, c := range []struct {
+		request  QuoteRequest
+		expected QuoteResponse
+	}{
+		{QuoteRequest{"buy", "BTC", "USD", "1", ""},
+			QuoteResponse{"10001.00", "10001.00", "10001.00", "0.00", "10001.00", "USD",
+				"1.00000000", "BTC", nil}},
+		{QuoteRequest{"buy", "LTC", "USD", "25", ""},
+			QuoteResponse{"100.55", "2513.75", "2513.75", "0.00", "2513.75", "USD",
+				"25.00000000", "LTC", nil}},
+	} {
+		// the l2update may still be on its way once the book is running
+		var q QuoteResponse
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			status, body := requestQuote(t, ts, c.request)
+			if status != http.StatusOK {
+				t.Fatalf("%+v: expected status 200, got %d: %s", c.request, status, body)
+			}
+			q = QuoteResponse{}
+			if err := json.Unmarshal(body, &q); err != nil {
+				t.Fatalf("%s", err)
+			}
+			q.Legs = nil
+			if reflect.DeepEqual(q, c.expected) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !reflect.DeepEqual(q, c.expected) {
+			t.Errorf("%+v: expected %+v, got %+v", c.request, c.expected, q)
+		}
+	}
+}
+
+func TestOwnOrders(t *testing.T) {
+	// one of our orders opens after the snapshot, and another was already
+	// open when it was taken
+	script := append(integrationScript, gdaxtest.Message{Type: gdax.OpenMessage,
+		Sequence: 106, ProductID: "BTC-USD", OrderID: "own-ask", Side: gdax.AskSide,
+		Price: "10001.25", RemainingSize: "5", UserID: "user", ProfileID: "profile"})
+
+	fake := gdaxtest.NewServer(integrationBooks, script)
+	t.Cleanup(fake.Close)
+	fake.Credentials = &gdax.Credentials{
+		Key: "key", Secret: "c2VjcmV0", Passphrase: "passphrase"}
+	fake.Orders = []gdax.Order{{ID: "btc-ask-1", ProductID: "BTC-USD", Status: "open"}}
+
+	t.Setenv("GDAX_API_URL", fake.URL)
+	t.Setenv("GDAX_WEBSOCKET_URL", fake.WebsocketURL())
+	t.Setenv("GDAX_PRODUCT_IDS", "BTC-USD")
+	t.Setenv("GDAX_API_KEY", "key")
+	t.Setenv("GDAX_API_SECRET", "c2VjcmV0")
+	t.Setenv("GDAX_API_PASSPHRASE", "passphrase")
+
+	ts := startServer(t, map[string]int64{"BTC-USD": 106})
+
+	// the best asks, at 10001.00 and 10001.25, are ours
+	status, body := requestQuote(t, ts, QuoteRequest{"buy", "BTC", "USD", "1", ""})
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+	var q QuoteResponse
+	if err := json.Unmarshal(body, &q); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if q.Price != "10001.50" {
+		t.Errorf("expected our own orders to be left out, got a price of %s", q.Price)
+	}
+}
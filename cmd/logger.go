@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/sha256"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -30,6 +32,23 @@ func (l *requestLogger) WriteHeader(status int) {
 	l.ResponseWriter.WriteHeader(status)
 }
 
+// Flush implements http.Flusher, for streaming responses
+func (l *requestLogger) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, for websocket connections
+func (l *requestLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := l.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response can't be hijacked")
+	}
+	l.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
 type serverLogger struct {
 	handler http.Handler
 }
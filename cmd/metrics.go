@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text exposition format.
+// The format is simple enough that it's written here rather than pulling in
+// the Prometheus client library.
+
+var defaultBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// metrics that are recorded as events happen. metrics belonging to the feed
+// and order books are collected from them at scrape time
+type metrics struct {
+	httpRequests        *counterVec
+	httpRequestDuration *histogramVec
+	quotes              *counterVec
+	streamQuotes        *counterVec
+	snapshotDuration    *histogramVec
+	feedErrors          *counterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		httpRequests: newCounterVec("quoted_http_requests_total",
+			"HTTP requests served, by endpoint and status.",
+			"endpoint", "status"),
+		httpRequestDuration: newHistogramVec("quoted_http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by endpoint and status.",
+			defaultBuckets, "endpoint", "status"),
+		quotes: newCounterVec("quoted_quotes_total",
+			"Quotes produced, by product and order book action.",
+			"product_id", "action"),
+		streamQuotes: newCounterVec("quoted_stream_quotes_total",
+			"Quotes recomputed for streams, by product and order book action.",
+			"product_id", "action"),
+		snapshotDuration: newHistogramVec("quoted_gdax_snapshot_duration_seconds",
+			"Time taken to fetch order book snapshots from the GDAX REST API.",
+			defaultBuckets, "product_id"),
+		feedErrors: newCounterVec("quoted_feed_errors_total",
+			"Error messages received from the GDAX websocket feed, by message.",
+			"message"),
+	}
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+type counterVec struct {
+	*sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{&sync.Mutex{}, name, help, labels, map[string]float64{}}
+}
+
+// Inc increments the counter with the given label values, which must be in
+// the same order as the labels the counter was created with
+func (c *counterVec) Inc(values ...string) {
+	c.Lock()
+	c.values[labelKey(values)]++
+	c.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		writeSample(w, c.name, c.labels, strings.Split(key, "\xff"), c.values[key])
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	*sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+}
+
+func newHistogramVec(
+	name, help string, buckets []float64, labels ...string,
+) *histogramVec {
+	return &histogramVec{
+		&sync.Mutex{}, name, help, labels, buckets, map[string]*histogram{},
+	}
+}
+
+// Observe records a value in the histogram with the given label values
+func (h *histogramVec) Observe(v float64, values ...string) {
+	h.Lock()
+	defer h.Unlock()
+
+	key := labelKey(values)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		hist := h.values[key]
+		values := strings.Split(key, "\xff")
+		bucket := func(le string) []string {
+			return append(append([]string{}, values...), le)
+		}
+		for i, upper := range h.buckets {
+			writeSample(w, h.name+"_bucket", labels,
+				bucket(formatFloat(upper)), float64(hist.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", labels, bucket("+Inf"), float64(hist.count))
+		writeSample(w, h.name+"_sum", h.labels, values, hist.sum)
+		writeSample(w, h.name+"_count", h.labels, values, float64(hist.count))
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeSample(w io.Writer, name string, labels, values []string, v float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, label := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelEscaper.Replace(values[i]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(v))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// writeFeedMetrics writes metrics collected by the feed and order books, which
+// are read from them at scrape time rather than being pushed
+func (s *Server) writeFeedMetrics(w io.Writer) {
+	name := "quoted_feed_messages_total"
+	writeHeader(w, name, "Messages received from the GDAX websocket feed, by type.", "counter")
+	counts := map[string]int64{}
+	if s.feed != nil {
+		counts = s.feed.MessageCounts()
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		writeSample(w, name, []string{"type"}, []string{t}, float64(counts[t]))
+	}
+
+	orderbooks := s.books()
+	productIDs := make([]string, 0, len(orderbooks))
+	for p := range orderbooks {
+		productIDs = append(productIDs, p)
+	}
+	sort.Strings(productIDs)
+
+	labels := []string{"product_id"}
+	name = "quoted_orderbook_dropped_messages_total"
+	writeHeader(w, name, "Feed messages missed by each order book, detected by sequence gaps.", "counter")
+	for _, p := range productIDs {
+		writeSample(w, name, labels, []string{p},
+			float64(orderbooks[p].Status().TotalDroppedMessages))
+	}
+
+	name = "quoted_orderbook_resets_total"
+	writeHeader(w, name, "Times each order book has been reloaded from a snapshot.", "counter")
+	for _, p := range productIDs {
+		writeSample(w, name, labels, []string{p}, float64(orderbooks[p].Status().Resets))
+	}
+
+	name = "quoted_orderbook_feed_lag"
+	writeHeader(w, name, "Feed messages buffered for each order book, waiting to be applied.", "gauge")
+	for _, p := range productIDs {
+		writeSample(w, name, labels, []string{p}, float64(orderbooks[p].Status().Feed.Lag))
+	}
+
+	name = "quoted_orderbook_feed_dropped_messages_total"
+	writeHeader(w, name, "Feed messages dropped for each order book because its buffer was full.", "counter")
+	for _, p := range productIDs {
+		writeSample(w, name, labels, []string{p}, float64(orderbooks[p].Status().Feed.Dropped))
+	}
+
+	name = "quoted_orderbook_depth"
+	writeHeader(w, name, "Entries on each side of each order book, orders in level 3 books and prices in level 2 books.", "gauge")
+	for _, p := range productIDs {
+		status := orderbooks[p].Status()
+		writeSample(w, name, []string{"product_id", "side"},
+			[]string{p, "bid"}, float64(status.BidDepth))
+		writeSample(w, name, []string{"product_id", "side"},
+			[]string{p, "ask"}, float64(status.AskDepth))
+	}
+}
+
+// GET /metrics
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	s.metrics.httpRequests.write(&buf)
+	s.metrics.httpRequestDuration.write(&buf)
+	s.metrics.quotes.write(&buf)
+	s.metrics.streamQuotes.write(&buf)
+	s.metrics.snapshotDuration.write(&buf)
+	s.metrics.feedErrors.write(&buf)
+	s.writeFeedMetrics(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// serverMetrics records the count and latency of requests to each endpoint.
+// endpoints are identified by the mux pattern they match to keep the number
+// of series bounded
+type serverMetrics struct {
+	mux     *http.ServeMux
+	metrics *metrics
+}
+
+func (s *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	l := &requestLogger{w, http.StatusOK}
+	s.mux.ServeHTTP(l, r)
+
+	_, endpoint := s.mux.Handler(r)
+	if len(endpoint) == 0 {
+		endpoint = "unmatched"
+	}
+	status := strconv.Itoa(l.status)
+	s.metrics.httpRequests.Inc(endpoint, status)
+	s.metrics.httpRequestDuration.Observe(time.Since(start).Seconds(), endpoint, status)
+}
+
+// snapshotTimer is an http.RoundTripper that records the latency of order
+// book snapshot requests to the GDAX REST API
+type snapshotTimer struct {
+	transport http.RoundTripper
+	metrics   *metrics
+}
+
+func (t *snapshotTimer) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.transport.RoundTrip(r)
+
+	// snapshot paths look like /products/BTC-USD/book
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if err == nil && len(parts) == 3 && parts[0] == "products" && parts[2] == "book" {
+		t.metrics.snapshotDuration.Observe(time.Since(start).Seconds(), parts[1])
+	}
+
+	return response, err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+)
+
+func TestCounterExposition(t *testing.T) {
+	c := newCounterVec("test_total", "Things counted.", "kind", "path")
+	c.Inc("b", `say "hi"`)
+	c.Inc("a", "back\\slash\nnewline")
+	c.Inc("b", `say "hi"`)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	expected := `# HELP test_total Things counted.
+# TYPE test_total counter
+test_total{kind="a",path="back\\slash\nnewline"} 1
+test_total{kind="b",path="say \"hi\""} 2
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Time taken.", []float64{.5, 1, 2.5}, "product_id")
+	for _, v := range []float64{.25, .75, 1, 3} {
+		h.Observe(v, "BTC-USD")
+	}
+
+	// buckets are cumulative, +Inf holds every observation
+	var buf bytes.Buffer
+	h.write(&buf)
+	expected := `# HELP test_seconds Time taken.
+# TYPE test_seconds histogram
+test_seconds_bucket{product_id="BTC-USD",le="0.5"} 1
+test_seconds_bucket{product_id="BTC-USD",le="1"} 3
+test_seconds_bucket{product_id="BTC-USD",le="2.5"} 3
+test_seconds_bucket{product_id="BTC-USD",le="+Inf"} 4
+test_seconds_sum{product_id="BTC-USD"} 5
+test_seconds_count{product_id="BTC-USD"} 4
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+// fakeFeed is a Feed with fixed message counts
+type fakeFeed map[string]int64
+
+func (f fakeFeed) Connected() bool                 { return true }
+func (f fakeFeed) MessageCounts() map[string]int64 { return f }
+func (f fakeFeed) Close()                          {}
+
+func TestMetricsCount(t *testing.T) {
+	s := newTestServer()
+	s.AddOrderBook("LTC-USD", &fakeQuoter{size: "2", funds: "100.02", running: true})
+	s.feed = fakeFeed{"open": 3, "match": 1}
+
+	for i := 0; i < 2; i++ {
+		w := postQuote(s, `{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"2"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	// snapshot requests are timed, other requests to the API aren't
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer api.Close()
+	client := &http.Client{Transport: &snapshotTimer{http.DefaultTransport, s.metrics}}
+	for _, path := range []string{"/products/BTC-USD/book?level=3", "/products"} {
+		response, err := client.Get(api.URL + path)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		response.Body.Close()
+	}
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, expected := range []string{
+		`quoted_quotes_total{product_id="LTC-USD",action="buy"} 2`,
+		`quoted_gdax_snapshot_duration_seconds_count{product_id="BTC-USD"} 1`,
+		`quoted_http_requests_total{endpoint="/quote",status="200"} 2`,
+		`quoted_feed_messages_total{type="match"} 1`,
+		`quoted_feed_messages_total{type="open"} 3`,
+	} {
+		if !strings.Contains(string(body), expected+"\n") {
+			t.Errorf("expected %s in\n%s", expected, body)
+		}
+	}
+	if strings.Contains(string(body), `snapshot_duration_seconds_count{product_id="products"}`) {
+		t.Errorf("only snapshot requests should be timed")
+	}
+}
+
+// messageSource is a MessageSource that remembers its subscribers so
+// messages can be sent to them
+type messageSource struct {
+	subscribers []chan gdax.Message
+}
+
+func (s *messageSource) Subscribe(c chan gdax.Message, filter gdax.Filter) *gdax.Subscription {
+	s.subscribers = append(s.subscribers, c)
+	return nil
+}
+
+func TestMetricsCountFeedErrors(t *testing.T) {
+	s := newTestServer()
+	for _, p := range []string{"BTC-USD", "ETH-USD", "LTC-USD"} {
+		s.AddOrderBook(p, &fakeQuoter{size: "1", funds: "1", running: true})
+	}
+	source := &messageSource{}
+	s.reportFeedErrors(source)
+
+	// errors aren't about any one book, so they're counted once
+	if len(source.subscribers) != 1 {
+		t.Fatalf("expected one subscriber for errors, got %d", len(source.subscribers))
+	}
+	source.subscribers[0] <- gdax.Error{
+		MessageHeader: gdax.MessageHeader{Type: gdax.ErrorMessage},
+		Message:       "Failed to subscribe",
+		Reason:        "XRP-USD is not a valid product",
+	}
+
+	expected := `quoted_feed_errors_total{message="Failed to subscribe"} 1` + "\n"
+	var body []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body, _ = ioutil.ReadAll(w.Body)
+		if strings.Contains(string(body), expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected %s in\n%s", expected, body)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/satori/go.uuid"
 
@@ -14,25 +15,156 @@ import (
 
 // QuoteRequest contains parameters needed for producing a quote. A JSON object
 // that can be unmarshaled into this struct is expected to be received in the
-// request body for a POST request to /quote
+// request body for a POST request to /quote, which responds with a FirmQuote.
+// Posting to /quote?detail=true adds a QuoteDetail to each leg of the
+// response.
+//
+// Amount is of the base currency unless AmountCurrency is the quote currency,
+// in which case the quote is for spending (or receiving) exactly that much of
+// the quote currency.
 type QuoteRequest struct {
-	Action        string `json:"action"`
-	BaseCurrency  string `json:"base_currency"`
-	QuoteCurrency string `json:"quote_currency"`
-	Amount        string `json:"amount"`
+	Action         string `json:"action"`
+	BaseCurrency   string `json:"base_currency"`
+	QuoteCurrency  string `json:"quote_currency"`
+	Amount         string `json:"amount"`
+	AmountCurrency string `json:"amount_currency,omitempty"`
+}
+
+// exchange returns the currency given up and the currency received. buying
+// the base currency exchanges the quote currency for it, selling is the other
+// way around
+func (q QuoteRequest) exchange() (from, to string) {
+	if q.Action == "sell" {
+		return q.BaseCurrency, q.QuoteCurrency
+	}
+	return q.QuoteCurrency, q.BaseCurrency
 }
 
 // QuoteResponse contains fields representing a price quote for a quantity of a
 // product. It is marshaled into a JSON object that is returned from POST
 // requests to /quote
+//
+// Price is in the quote currency. Total and Currency are the quote currency side
+// of the trade and BaseAmount and BaseCurrency the base currency side, one of
+// which is the amount requested. GrossTotal is the same as Total, what's
+// exchanged on the order books, Fees what's charged on top of it and NetTotal
+// what's paid for a buy, or received for a sell, after them. They're all in
+// the quote currency, and when the amount requested is in the quote currency
+// it's the NetTotal. Legs lists the order books the quote was
+// walked through, which is more than one when there's no book for the pair and
+// the quote is routed through another currency.
 type QuoteResponse struct {
-	Price    string `json:"price"`
-	Total    string `json:"total"`
-	Currency string `json:"currency"`
+	Price        string     `json:"price"`
+	Total        string     `json:"total"`
+	GrossTotal   string     `json:"gross_total"`
+	Fees         string     `json:"fees"`
+	NetTotal     string     `json:"net_total"`
+	Currency     string     `json:"currency"`
+	BaseAmount   string     `json:"base_amount"`
+	BaseCurrency string     `json:"base_currency"`
+	Legs         []QuoteLeg `json:"legs"`
+}
+
+// QuoteLeg is one order book a quote was routed through. Action is the order
+// book action taken on the product, Size and Funds the amounts of its base and
+// quote currencies exchanged, and Price is in its quote currency.
+type QuoteLeg struct {
+	ProductID string `json:"product_id"`
+	Action    string `json:"action"`
+	Price     string `json:"price"`
+	Size      string `json:"size"`
+	Funds     string `json:"funds"`
+
+	Detail *QuoteDetail `json:"detail,omitempty"`
+}
+
+// QuoteDetail describes the order book a leg was quoted from and how the
+// quote walked it. Prices are in the product's quote currency, and the mid
+// price has one more decimal place than other prices so that it's exact.
+// SlippageBps is how much worse than the best price on the side taken the
+// leg's price is, in basis points. Sequence is the order book's sequence and
+// Time when the book was read.
+type QuoteDetail struct {
+	BestBid        string      `json:"best_bid,omitempty"`
+	BestAsk        string      `json:"best_ask,omitempty"`
+	MidPrice       string      `json:"mid_price,omitempty"`
+	WorstPrice     string      `json:"worst_price"`
+	LevelsConsumed int         `json:"levels_consumed"`
+	SlippageBps    string      `json:"slippage_bps"`
+	Sequence       int64       `json:"sequence"`
+	Time           time.Time   `json:"time"`
+	Fills          []QuoteFill `json:"fills"`
+}
+
+// QuoteFill is the amount of a product's base currency taken at one price
+type QuoteFill struct {
+	Price string `json:"price"`
+	Size  string `json:"size"`
+}
+
+func (s *Server) newQuoteLeg(l legQuote, detail bool) QuoteLeg {
+	basePrecision := s.registry.CurrencyPrecision(l.product.BaseCurrency)
+	quotePrecision := s.registry.CurrencyPrecision(l.product.QuoteCurrency)
+	leg := QuoteLeg{
+		ProductID: l.product.ID,
+		Action:    l.action(),
+		Price:     l.quote.Funds.Div(l.quote.Size).StringFixed(l.product.PricePrecision()),
+		Size:      l.quote.Size.StringFixed(basePrecision),
+		Funds:     l.quote.Funds.StringFixed(quotePrecision),
+	}
+	if detail {
+		leg.Detail = s.newQuoteDetail(l)
+	}
+	return leg
+}
+
+func (s *Server) newQuoteDetail(l legQuote) *QuoteDetail {
+	q := l.quote
+	pricePrecision := l.product.PricePrecision()
+	basePrecision := s.registry.CurrencyPrecision(l.product.BaseCurrency)
+
+	detail := &QuoteDetail{
+		LevelsConsumed: len(q.Fills),
+		Sequence:       q.Sequence,
+		Time:           q.Time,
+		Fills:          make([]QuoteFill, len(q.Fills)),
+	}
+	for i, f := range q.Fills {
+		detail.Fills[i] = QuoteFill{
+			f.Price.StringFixed(pricePrecision),
+			f.Size.StringFixed(basePrecision),
+		}
+	}
+	if len(q.Fills) > 0 {
+		detail.WorstPrice = q.Fills[len(q.Fills)-1].Price.StringFixed(pricePrecision)
+	}
+
+	if !q.BestBid.IsZero() {
+		detail.BestBid = q.BestBid.StringFixed(pricePrecision)
+	}
+	if !q.BestAsk.IsZero() {
+		detail.BestAsk = q.BestAsk.StringFixed(pricePrecision)
+	}
+	if !q.BestBid.IsZero() && !q.BestAsk.IsZero() {
+		mid := q.BestBid.Add(q.BestAsk).Div(gdax.DecimalFromInt(2))
+		detail.MidPrice = mid.StringFixed(pricePrecision + 1)
+	}
+
+	// buying pays more than the best ask, selling receives less than the
+	// best bid
+	top, slippage := q.BestAsk, q.Funds.Div(q.Size).Sub(q.BestAsk)
+	if l.action() == gdax.SellAction {
+		top, slippage = q.BestBid, q.BestBid.Sub(q.Funds.Div(q.Size))
+	}
+	if !top.IsZero() {
+		detail.SlippageBps = slippage.Div(top).Mul(gdax.DecimalFromInt(10000)).StringFixed(2)
+	}
+
+	return detail
 }
 
 // POST /quote
-func handleQuote(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleQuote(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", "POST")
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -41,76 +173,194 @@ func handleQuote(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
+	detail, err := parseDetail(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	var q QuoteRequest
-	err := decoder.Decode(&q)
+	err = decoder.Decode(&q)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if q.Action != "buy" && q.Action != "sell" {
-		writeError(w, http.StatusBadRequest, "action must be 'buy' or 'sell'")
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, traceIDKey, uuid.NewV4().String())
+
+	response, sequences, err := s.quote(q, detail, s.metrics.quotes)
+	if e, ok := err.(*quoteError); ok {
+		writeError(w, e.status, e.message)
 		return
 	}
 
-	floatAmount, err := strconv.ParseFloat(q.Amount, 64)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
+	now := s.now()
+	firm := FirmQuote{
+		ID:            uuid.NewV4().String(),
+		Request:       q,
+		QuoteResponse: response,
+		Sequences:     sequences,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(s.config.QuoteTTL),
+	}
+	if err := s.quotes.Put(firm); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if floatAmount <= 0 {
-		writeError(w, http.StatusBadRequest, "amount must be a positive number")
+	body, err := json.Marshal(firm)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	productID := gdax.ProductIDForCurrencyPair(q.BaseCurrency, q.QuoteCurrency)
-	if len(productID) < 1 {
-		writeError(w, http.StatusBadRequest, "invalid currency pair")
-		return
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// parseDetail reads the detail query parameter
+func parseDetail(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("detail")
+	if len(v) == 0 {
+		return false, nil
+	}
+	detail, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("detail must be true or false")
 	}
+	return detail, nil
+}
 
-	ctx := r.Context()
-	ctx = context.WithValue(ctx, traceIDKey, uuid.NewV4().String())
+// quoteError is an error producing a quote, with the HTTP status it's
+// reported with
+type quoteError struct {
+	status  int
+	message interface{}
+}
 
-	action := q.Action
-	inverse := false
-	if productID[0:3] != q.BaseCurrency {
-		inverse = true
-		if q.Action == "buy" {
-			action = "sell"
-		} else {
-			action = "buy"
-		}
+func (e *quoteError) Error() string {
+	return fmt.Sprint(e.message)
+}
+
+// quote produces a quote for a request from the order books, also returning
+// the sequence of each book it was walked through, and counts it in counted.
+// Errors are *quoteError
+func (s *Server) quote(q QuoteRequest, detail bool, counted *counterVec,
+) (QuoteResponse, map[string]int64, error) {
+	fail := func(status int, message interface{}) (QuoteResponse, map[string]int64, error) {
+		return QuoteResponse{}, nil, &quoteError{status, message}
+	}
+
+	if q.Action != "buy" && q.Action != "sell" {
+		return fail(http.StatusBadRequest, "action must be 'buy' or 'sell'")
 	}
 
-	price, quantity, err := orderbooks[productID].Quote(
-		action, q.QuoteCurrency, floatAmount, inverse)
+	amount, err := gdax.NewDecimal(q.Amount)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
+		return fail(http.StatusBadRequest, err)
 	}
 
-	stringPrice := strconv.FormatFloat(price, 'f',
-		gdax.CurrencyPrecision(q.QuoteCurrency), 64)
-	stringQuantity := strconv.FormatFloat(quantity, 'f',
-		gdax.CurrencyPrecision(q.QuoteCurrency), 64)
+	if amount.Sign() <= 0 {
+		return fail(http.StatusBadRequest, "amount must be a positive number")
+	}
 
-	body, err := json.Marshal(
-		QuoteResponse{stringPrice, stringQuantity, q.QuoteCurrency})
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
+	amountCurrency := q.AmountCurrency
+	if len(amountCurrency) == 0 {
+		amountCurrency = q.BaseCurrency
+	}
+	if amountCurrency != q.BaseCurrency && amountCurrency != q.QuoteCurrency {
+		return fail(http.StatusBadRequest,
+			"amount_currency must be the base or quote currency")
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
+	if q.BaseCurrency == q.QuoteCurrency {
+		return fail(http.StatusBadRequest, "invalid currency pair")
+	}
+
+	from, to := q.exchange()
+	routes := s.findRoutes(from, to)
+	if len(routes) == 0 {
+		return fail(http.StatusBadRequest, "unsupported currency pair")
+	}
+
+	// fees are charged in the quote currency, so on what's paid for a buy and
+	// on what's received for a sell
+	feesIn := q.Action == "buy"
+	rq, err := s.bestQuote(routes, amount, amountCurrency == from, feesIn)
+	if e, ok := err.(*routeError); ok && e.err == gdax.ErrBookUnavailable {
+		return fail(http.StatusServiceUnavailable, err)
+	} else if err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+
+	legs := make([]QuoteLeg, len(rq.legs))
+	sequences := make(map[string]int64, len(rq.legs))
+	for i, l := range rq.legs {
+		counted.Inc(l.product.ID, l.action())
+		legs[i] = s.newQuoteLeg(l, detail)
+		sequences[l.product.ID] = l.quote.Sequence
+	}
+
+	baseAmount, quoteAmount := rq.out, rq.in
+	if q.Action == "sell" {
+		baseAmount, quoteAmount = quoteAmount, baseAmount
+	}
+
+	// account for prices that are too precise for their currency
+	basePrecision := s.registry.CurrencyPrecision(q.BaseCurrency)
+	quotePrecision := s.registry.CurrencyPrecision(q.QuoteCurrency)
+	var price, fees, netAmount gdax.Decimal
+	if amountCurrency == q.BaseCurrency {
+		// the total follows from the rounded price so that it's always the
+		// price times the amount
+		price = quoteAmount.Div(amount).Round(quotePrecision)
+		quoteAmount = price.Mul(amount).Round(quotePrecision)
+		baseAmount = amount
+
+		fees = rq.fees.Round(quotePrecision)
+		if feesIn {
+			netAmount = quoteAmount.Add(fees)
+		} else {
+			netAmount = quoteAmount.Sub(fees)
+		}
+	} else {
+		baseAmount = baseAmount.Round(basePrecision)
+		if baseAmount.IsZero() {
+			return fail(http.StatusBadRequest,
+				fmt.Sprintf("amount is too small to buy or sell any %s", q.BaseCurrency))
+		}
+
+		// the amount includes fees, so the fees are whatever's left of it
+		// once the books are paid
+		netAmount = amount
+		quoteAmount = quoteAmount.Round(quotePrecision)
+		if feesIn {
+			fees = netAmount.Sub(quoteAmount)
+		} else {
+			fees = quoteAmount.Sub(netAmount)
+		}
+		price = quoteAmount.Div(baseAmount).Round(quotePrecision)
+	}
+
+	return QuoteResponse{
+		price.StringFixed(quotePrecision),
+		quoteAmount.StringFixed(quotePrecision),
+		quoteAmount.StringFixed(quotePrecision),
+		fees.StringFixed(quotePrecision),
+		netAmount.StringFixed(quotePrecision),
+		q.QuoteCurrency,
+		baseAmount.StringFixed(basePrecision),
+		q.BaseCurrency,
+		legs,
+	}, sequences, nil
 }
 
 func writeError(w http.ResponseWriter, status int, message interface{}) {
 	w.WriteHeader(status)
-	// fmt.Sprintf is used instead of json.Marshal because marshaling can produce
-	// an error and we want to guarantee a JSON-formatted response
-	w.Write([]byte(fmt.Sprintf(`{"message":"%s"}`, message)))
+	// only the message string is marshaled, because marshaling a string can't
+	// produce an error and we want to guarantee a JSON-formatted response
+	escaped, _ := json.Marshal(fmt.Sprint(message))
+	w.Write([]byte(fmt.Sprintf(`{"message":%s}`, escaped)))
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+	"github.com/akb/quoted/gdax/gdaxtest"
+)
+
+// fakeQuoter returns a fixed quote and records the arguments it was called
+// with
+type fakeQuoter struct {
+	sync.Mutex
+	size, funds string
+	err         error
+	running     bool
+
+	// what the book's at when it's read
+	sequence int64
+	at       time.Time
+
+	action      string
+	amount      gdax.Decimal
+	quotedFunds bool
+
+	changed chan struct{}
+}
+
+func (f *fakeQuoter) Quote(action string, amount gdax.Decimal, funds bool) (gdax.Quote, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.action, f.amount, f.quotedFunds = action, amount, funds
+	if f.err != nil {
+		return gdax.Quote{}, f.err
+	}
+	size, _ := gdax.NewDecimal(f.size)
+	total, _ := gdax.NewDecimal(f.funds)
+	return gdax.Quote{Size: size, Funds: total, Sequence: f.sequence, Time: f.at}, nil
+}
+
+func (f *fakeQuoter) Status() gdax.LiveOrderBookStatus {
+	f.Lock()
+	defer f.Unlock()
+	state := "loading"
+	if f.running {
+		state = "running"
+	}
+	return gdax.LiveOrderBookStatus{State: state, Running: f.running}
+}
+
+func (f *fakeQuoter) Changed() <-chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+	if f.changed == nil {
+		f.changed = make(chan struct{})
+	}
+	return f.changed
+}
+
+// update changes the quoter with fn and wakes anything waiting for a change
+func (f *fakeQuoter) update(fn func(f *fakeQuoter)) {
+	f.Lock()
+	defer f.Unlock()
+	fn(f)
+	if f.changed != nil {
+		close(f.changed)
+		f.changed = nil
+	}
+}
+
+// newTestServer returns a server with no order books whose registry lists the
+// usual products
+func newTestServer() *Server {
+	s := NewServer(Config{QuoteTTL: time.Minute})
+	s.SetRegistry(gdaxtest.NewRegistry(
+		"BTC-USD", "ETH-USD", "ETH-BTC", "LTC-USD", "LTC-BTC"))
+	return s
+}
+
+func postQuote(s *Server, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(body))
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestQuote(t *testing.T) {
+	book := &fakeQuoter{size: "2", funds: "100.02", running: true}
+	s := newTestServer()
+	s.AddOrderBook("LTC-USD", book)
+
+	w := postQuote(s, `{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"2"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var q QuoteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := QuoteResponse{"50.01", "100.02", "100.02", "0.00", "100.02", "USD",
+		"2.00000000", "LTC",
+		[]QuoteLeg{{"LTC-USD", "buy", "50.01", "2.00000000", "100.02", nil}}}
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("expected %+v, got %+v", expected, q)
+	}
+
+	if book.action != gdax.BuyAction || book.quotedFunds {
+		t.Errorf("expected a buy by size, got %s funds=%v", book.action, book.quotedFunds)
+	}
+}
+
+func TestQuoteAmountCurrency(t *testing.T) {
+	for _, c := range []struct {
+		body        string
+		book        *fakeQuoter
+		action      string
+		quotedFunds bool
+		expected    QuoteResponse
+	}{
+		// buy BTC with exactly 250 USD
+		{`{"action":"buy","base_currency":"BTC","quote_currency":"USD","amount":"250","amount_currency":"USD"}`,
+			&fakeQuoter{size: "0.025", funds: "250"}, gdax.BuyAction, true,
+			QuoteResponse{"10000.00", "250.00", "250.00", "0.00", "250.00", "USD",
+				"0.02500000", "BTC", nil}},
+		// buy 100 USD with BTC, which is selling BTC for 100 USD
+		{`{"action":"buy","base_currency":"USD","quote_currency":"BTC","amount":"100"}`,
+			&fakeQuoter{size: "0.01", funds: "100"}, gdax.SellAction, true,
+			QuoteResponse{"0.00010000", "0.01000000", "0.01000000", "0.00000000", "0.01000000", "BTC",
+				"100.00", "USD", nil}},
+		// buy USD with exactly 0.01 BTC
+		{`{"action":"buy","base_currency":"USD","quote_currency":"BTC","amount":"0.01","amount_currency":"BTC"}`,
+			&fakeQuoter{size: "0.01", funds: "100"}, gdax.SellAction, false,
+			QuoteResponse{"0.00010000", "0.01000000", "0.01000000", "0.00000000", "0.01000000", "BTC",
+				"100.00", "USD", nil}},
+	} {
+		c.book.running = true
+		s := newTestServer()
+		s.AddOrderBook("BTC-USD", c.book)
+
+		w := postQuote(s, c.body)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", c.body, w.Code, w.Body)
+			continue
+		}
+
+		var q QuoteResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &q); err != nil {
+			t.Errorf("%s: %s", c.body, err)
+			continue
+		}
+		q.Legs = nil
+		if !reflect.DeepEqual(q, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.body, c.expected, q)
+		}
+
+		if c.book.action != c.action || c.book.quotedFunds != c.quotedFunds {
+			t.Errorf("%s: expected %s funds=%v, got %s funds=%v", c.body,
+				c.action, c.quotedFunds, c.book.action, c.book.quotedFunds)
+		}
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	s := newTestServer()
+	s.AddOrderBook("BTC-USD", &fakeQuoter{err: gdax.ErrBookUnavailable})
+	s.AddOrderBook("LTC-USD", &fakeQuoter{err: gdax.ErrInsufficientDepth})
+	s.AddOrderBook("LTC-BTC", &fakeQuoter{size: "0.001", funds: "0.00001", running: true})
+
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		{`{"action":"waffle","base_currency":"BTC","quote_currency":"USD","amount":"1"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"BTC","quote_currency":"USD","amount":"-1"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"BTC","quote_currency":"USD","amount":"one"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"BTC","quote_currency":"USD","amount":"1","amount_currency":"ETH"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"ARK","quote_currency":"USD","amount":"1"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"ETH","quote_currency":"USD","amount":"1"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"1"}`, http.StatusBadRequest},
+		{`{"action":"buy","base_currency":"BTC","quote_currency":"USD","amount":"1"}`, http.StatusServiceUnavailable},
+		{`{"action":"buy","base_currency":"LTC","quote_currency":"BTC","amount":"0.001"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	} {
+		w := postQuote(s, c.body)
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.body, c.status, w.Code)
+		}
+
+		var e struct{ Message string }
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || len(e.Message) == 0 {
+			t.Errorf("%s: expected an error message, got %s", c.body, w.Body)
+		}
+	}
+}
+
+func TestQuoteMethodNotAllowed(t *testing.T) {
+	s := newTestServer()
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/quote", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/akb/quoted/gdax"
+)
+
+// the most order books a quote is routed through
+const maxRouteLegs = 3
+
+// leg is one step of a route, exchanging one currency for another on a
+// product's order book
+type leg struct {
+	product  gdax.Product
+	from, to string
+}
+
+// action returns the order book action that exchanges from for to
+func (l leg) action() string {
+	if l.to == l.product.BaseCurrency {
+		return gdax.BuyAction
+	}
+	return gdax.SellAction
+}
+
+// route is a path through order books from one currency to another
+type route []leg
+
+// legQuote is a quote for a single leg of a route
+type legQuote struct {
+	leg
+	quote gdax.Quote
+}
+
+// routeQuote is a quote along a route. in and out are the amounts of the
+// route's first and last currencies exchanged on the order books, and fees
+// what's charged on top of them, in whichever currency they're charged in
+type routeQuote struct {
+	in, out gdax.Decimal
+	fees    gdax.Decimal
+	legs    []legQuote
+}
+
+// routeError is an error quoting one leg of a route
+type routeError struct {
+	productID string
+	err       error
+}
+
+func (e *routeError) Error() string {
+	return fmt.Sprintf("%s %s", e.productID, e.err)
+}
+
+// findRoutes returns the shortest routes from one currency to another through
+// the server's order books, or none if they aren't connected within
+// maxRouteLegs books
+func (s *Server) findRoutes(from, to string) []route {
+	books := s.books()
+	productIDs := make([]string, 0, len(books))
+	for productID := range books {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Strings(productIDs)
+
+	// the legs that leave each currency
+	legs := map[string][]leg{}
+	for _, productID := range productIDs {
+		p, ok := s.registry.Product(productID)
+		if !ok {
+			continue
+		}
+		base, quote := p.BaseCurrency, p.QuoteCurrency
+		legs[base] = append(legs[base], leg{p, base, quote})
+		legs[quote] = append(legs[quote], leg{p, quote, base})
+	}
+
+	// breadth first, so the first routes found are the shortest
+	var routes []route
+	partial := []route{nil}
+	for n := 0; n < maxRouteLegs && len(routes) == 0; n++ {
+		var next []route
+		for _, r := range partial {
+			current := from
+			if len(r) > 0 {
+				current = r[len(r)-1].to
+			}
+
+			for _, l := range legs[current] {
+				if r.visits(l.to) || l.to == from {
+					continue
+				}
+				extended := append(append(route{}, r...), l)
+				if l.to == to {
+					routes = append(routes, extended)
+				} else {
+					next = append(next, extended)
+				}
+			}
+		}
+		partial = next
+	}
+
+	return routes
+}
+
+// visits reports whether the route passes through a currency
+func (r route) visits(currency string) bool {
+	for _, l := range r {
+		if l.to == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteRoute quotes an exchange along a route. When fixedIn is true amount is
+// of the route's first currency and the output of each leg is the input to the
+// next. Otherwise amount is of the last currency and the route is walked
+// backwards, each leg's input being the output required of the leg before it.
+func (s *Server) quoteRoute(r route, amount gdax.Decimal, fixedIn bool) (*routeQuote, error) {
+	rq := &routeQuote{legs: make([]legQuote, len(r))}
+	if fixedIn {
+		rq.in = amount
+	} else {
+		rq.out = amount
+	}
+
+	for n := range r {
+		i, currency := n, r[n].from
+		if !fixedIn {
+			i = len(r) - 1 - n
+			currency = r[i].to
+		}
+		l := r[i]
+
+		// the book takes amounts of the product's base currency, or of its
+		// quote currency as funds
+		funds := currency != l.product.BaseCurrency
+		book := s.orderbook(l.product.ID)
+		if book == nil {
+			// the product stopped being quoted since the route was found
+			return nil, &routeError{l.product.ID, gdax.ErrBookUnavailable}
+		}
+		q, err := book.Quote(l.action(), amount, funds)
+		if err == nil {
+			err = l.product.CheckSize(q.Size)
+		}
+		if err != nil {
+			return nil, &routeError{l.product.ID, err}
+		}
+		rq.legs[i] = legQuote{l, q}
+
+		// the other side of this leg is the amount for the next
+		if funds {
+			amount = q.Size
+		} else {
+			amount = q.Funds
+		}
+	}
+
+	if fixedIn {
+		rq.out = amount
+	} else {
+		rq.in = amount
+	}
+
+	return rq, nil
+}
+
+// quoteRouteWithFees quotes a route with fees charged on its input when feesIn
+// is true, adding to what's paid, or otherwise on its output, taking from
+// what's received. When the fixed amount is the one fees are charged on, it
+// includes them, so the books are quoted for the amount less fees.
+func (s *Server) quoteRouteWithFees(
+	r route, amount gdax.Decimal, fixedIn, feesIn bool,
+) (*routeQuote, error) {
+	rate := s.fees.rate(r)
+	if rate.Cmp(one) >= 0 {
+		return nil, fmt.Errorf("fees are 100%% or more of the quote")
+	}
+
+	if fixedIn == feesIn {
+		// paying amount including fees, or receiving it after fees
+		if feesIn {
+			amount = amount.Div(one.Add(rate))
+		} else {
+			amount = amount.Div(one.Sub(rate))
+		}
+	}
+
+	rq, err := s.quoteRoute(r, amount, fixedIn)
+	if err != nil {
+		return nil, err
+	}
+
+	if feesIn {
+		rq.fees = rq.in.Mul(rate)
+	} else {
+		rq.fees = rq.out.Mul(rate)
+	}
+	return rq, nil
+}
+
+// netIn and netOut are what's paid and received along a route including fees
+func (rq *routeQuote) netIn(feesIn bool) gdax.Decimal {
+	if feesIn {
+		return rq.in.Add(rq.fees)
+	}
+	return rq.in
+}
+
+func (rq *routeQuote) netOut(feesIn bool) gdax.Decimal {
+	if feesIn {
+		return rq.out
+	}
+	return rq.out.Sub(rq.fees)
+}
+
+// bestQuote quotes every route and returns the one that gives the most out for
+// a fixed input, or takes the least in for a fixed output, after fees. If no
+// route can be quoted the first route's error is returned.
+func (s *Server) bestQuote(
+	routes []route, amount gdax.Decimal, fixedIn, feesIn bool,
+) (*routeQuote, error) {
+	var best *routeQuote
+	var firstErr error
+	for _, r := range routes {
+		rq, err := s.quoteRouteWithFees(r, amount, fixedIn, feesIn)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if best == nil ||
+			(fixedIn && rq.netOut(feesIn).Cmp(best.netOut(feesIn)) > 0) ||
+			(!fixedIn && rq.netIn(feesIn).Cmp(best.netIn(feesIn)) < 0) {
+			best = rq
+		}
+	}
+
+	if best == nil {
+		return nil, firstErr
+	}
+	return best, nil
+}
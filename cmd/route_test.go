@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindRoutes(t *testing.T) {
+	s := newTestServer()
+	for _, productID := range []string{"BTC-USD", "ETH-BTC", "ETH-USD", "LTC-BTC"} {
+		s.AddOrderBook(productID, &fakeQuoter{running: true})
+	}
+
+	for _, c := range []struct {
+		from, to string
+		expected [][]string
+	}{
+		{"USD", "BTC", [][]string{{"BTC-USD"}}},
+		{"LTC", "ETH", [][]string{{"LTC-BTC", "ETH-BTC"}}},
+		{"USD", "ETH", [][]string{{"ETH-USD"}}},
+		{"LTC", "USD", [][]string{{"LTC-BTC", "BTC-USD"}}},
+		{"GBP", "USD", nil},
+	} {
+		var productIDs [][]string
+		for _, r := range s.findRoutes(c.from, c.to) {
+			var ids []string
+			for _, l := range r {
+				ids = append(ids, l.product.ID)
+			}
+			productIDs = append(productIDs, ids)
+		}
+
+		if !reflect.DeepEqual(productIDs, c.expected) {
+			t.Errorf("%s to %s: expected routes %v, got %v",
+				c.from, c.to, c.expected, productIDs)
+		}
+	}
+}
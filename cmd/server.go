@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/akb/quoted/gdax"
+)
+
+const origin = "http://localhost"
+
+// Quoter is an order book that quotes can be produced from.
+// *gdax.LiveOrderBook satisfies this interface
+type Quoter interface {
+	Quote(action string, amount gdax.Decimal, funds bool) (gdax.Quote, error)
+	Status() gdax.LiveOrderBookStatus
+	Changed() <-chan struct{}
+}
+
+// Feed is the source of market data for a server's order books. *gdax.Feed
+// satisfies this interface
+type Feed interface {
+	Connected() bool
+	MessageCounts() map[string]int64
+	Close()
+}
+
+// ProductFeed is a Feed whose products can be changed while it runs.
+// *gdax.Feed satisfies this interface
+type ProductFeed interface {
+	Feed
+	AddProduct(productID, channel string) error
+	RemoveProduct(productID string) error
+}
+
+// Server serves quotes over HTTP from a set of order books
+type Server struct {
+	config     Config
+	feed       Feed
+	recorder   *gdax.Recorder
+	registry   *gdax.Registry
+	orderbooks map[string]Quoter
+	quotes     QuoteStore
+	fees       *FeeSchedule
+	metrics    *metrics
+
+	// products can be added and removed while the server runs, so orderbooks
+	// is only touched under booksLock. changes are made one at a time
+	booksLock *sync.RWMutex
+	changing  *sync.Mutex
+
+	// starts a book for a product added while the server runs, nil when the
+	// feed's products can't be changed
+	newOrderBook func(productID string, level int) (*gdax.LiveOrderBook, error)
+
+	// the clock quotes are issued and accepted by
+	now func() time.Time
+
+	done   chan struct{}
+	cancel context.CancelFunc
+	http   *http.Server
+
+	// closed when the server starts shutting down, to end quote streams
+	closing chan struct{}
+
+	// Shutdown can be called more than once, such as by a signal handler
+	// after an error, but only stops things the first time
+	closingOnce  *sync.Once
+	shutdownOnce *sync.Once
+}
+
+// NewServer builds a server with no order books. Call Connect to start order
+// books for the configured products, or AddOrderBook to supply them directly
+func NewServer(config Config) *Server {
+	s := &Server{
+		config:     config,
+		registry:   gdax.NewRegistry(nil, nil),
+		orderbooks: map[string]Quoter{},
+		quotes:     newMemoryQuoteStore(),
+		fees:       config.Fees,
+		metrics:    newMetrics(),
+		booksLock:  &sync.RWMutex{},
+		changing:   &sync.Mutex{},
+		now:        time.Now,
+
+		done:    make(chan struct{}),
+		cancel:  func() {},
+		closing: make(chan struct{}),
+
+		closingOnce:  &sync.Once{},
+		shutdownOnce: &sync.Once{},
+	}
+
+	if s.fees == nil {
+		s.fees = &FeeSchedule{}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote", s.handleQuote)
+	mux.HandleFunc("/quote/", s.handleFirmQuote)
+	mux.HandleFunc("/quote/stream", s.handleQuoteStream)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/admin/products", s.admin(s.handleAdminProducts))
+	mux.HandleFunc("/admin/products/", s.admin(s.handleAdminProducts))
+
+	s.http = &http.Server{
+		Addr:    fmt.Sprintf(":%v", config.ListenPort),
+		Handler: &serverLogger{&serverMetrics{mux, s.metrics}},
+	}
+	s.http.RegisterOnShutdown(func() {
+		s.closingOnce.Do(func() { close(s.closing) })
+	})
+
+	return s
+}
+
+// Handler returns the server's HTTP handler
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// SetRegistry sets the products and currencies quotes are made for. Connect
+// sets it from the exchange, otherwise it must be called before the server
+// starts serving requests
+func (s *Server) SetRegistry(registry *gdax.Registry) {
+	s.registry = registry
+}
+
+// SetQuoteStore sets where issued quotes are kept. It must be called before
+// the server starts serving requests
+func (s *Server) SetQuoteStore(store QuoteStore) {
+	s.quotes = store
+}
+
+// AddOrderBook makes quotes for a product available from the order book q,
+// replacing any book the product already had
+func (s *Server) AddOrderBook(productID string, q Quoter) {
+	s.booksLock.Lock()
+	defer s.booksLock.Unlock()
+	s.orderbooks[productID] = q
+}
+
+// orderbook returns the book for a product, or nil if it isn't quoted
+func (s *Server) orderbook(productID string) Quoter {
+	s.booksLock.RLock()
+	defer s.booksLock.RUnlock()
+	return s.orderbooks[productID]
+}
+
+// books returns a copy of the books, by product, as they are now
+func (s *Server) books() map[string]Quoter {
+	s.booksLock.RLock()
+	defer s.booksLock.RUnlock()
+	books := make(map[string]Quoter, len(s.orderbooks))
+	for productID, q := range s.orderbooks {
+		books[productID] = q
+	}
+	return books
+}
+
+// removeOrderBook stops quoting a product, returning its book or nil if it
+// wasn't quoted
+func (s *Server) removeOrderBook(productID string) Quoter {
+	s.booksLock.Lock()
+	defer s.booksLock.Unlock()
+	q := s.orderbooks[productID]
+	delete(s.orderbooks, productID)
+	return q
+}
+
+// Connect connects to the GDAX feed and starts a live order book for each of
+// the configured products. If a replay file is configured the books are
+// driven by it instead
+func (s *Server) Connect() error {
+	if len(s.config.ReplayFile) > 0 {
+		return s.connectReplay()
+	}
+
+	transport := newClientLogger()
+	transport.Transport = &snapshotTimer{http.DefaultTransport, s.metrics}
+	client := &http.Client{
+		Timeout:   time.Second * 10,
+		Transport: transport,
+	}
+
+	api, err := gdax.NewAPI(s.config.APIURL)
+	if err != nil {
+		return fmt.Errorf("Error connecting to REST API\n%s", err)
+	}
+	api.Credentials = s.config.Credentials
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, traceIDKey, uuid.NewV4().String())
+	s.cancel = cancel
+
+	registry, err := api.GetRegistry(client, ctx)
+	if err != nil {
+		return fmt.Errorf("Error loading products and currencies\n%s", err)
+	}
+	s.registry = registry
+
+	productIDs, err := s.productIDs()
+	if err != nil {
+		return err
+	}
+
+	levels, err := s.bookLevels(productIDs)
+	if err != nil {
+		return err
+	}
+
+	// with credentials the feed tells our own orders apart, so the books can
+	// leave them out of quotes
+	feed, err := gdax.NewAuthenticatedFeed(s.config.WebsocketURL, origin,
+		s.config.Credentials, productIDs, feedChannels(productIDs, levels)...)
+	if err != nil {
+		return fmt.Errorf("Error establishing websocket connection\n%s", err)
+	}
+	s.feed = feed
+	s.reportFeedErrors(feed)
+	if len(s.config.FeedOverflow) > 0 {
+		feed.SetOverflowPolicy(s.config.FeedBufferSize, s.config.FeedOverflow)
+	}
+
+	if len(s.config.RecordFile) > 0 {
+		recorder, err := gdax.NewRecorder(s.config.RecordFile)
+		if err != nil {
+			return fmt.Errorf("Error opening record file\n%s", err)
+		}
+		s.recorder = recorder
+		feed.Record(recorder)
+
+		if err := recorder.RecordRegistry(registry); err != nil {
+			return fmt.Errorf("Error recording products and currencies\n%s", err)
+		}
+	}
+
+	for _, p := range productIDs {
+		lob, err := api.NewLiveOrderBook(client, ctx, feed, p, levels[p], s.done)
+		if err != nil {
+			return fmt.Errorf("Error while establishing order books\n%s", err)
+		}
+		s.addLiveOrderBook(p, lob)
+	}
+
+	s.newOrderBook = func(productID string, level int) (*gdax.LiveOrderBook, error) {
+		return api.NewLiveOrderBook(client, ctx, feed, productID, level, s.done)
+	}
+
+	return nil
+}
+
+// productIDs returns the configured products, checking that the exchange
+// lists them, or every online product if none are configured
+func (s *Server) productIDs() ([]string, error) {
+	if len(s.config.ProductIDs) == 0 {
+		return s.registry.OnlineProductIDs(), nil
+	}
+
+	for _, p := range s.config.ProductIDs {
+		if !s.registry.IsValidProductID(p) {
+			return nil, fmt.Errorf("%s is not a valid product id", p)
+		}
+	}
+	return s.config.ProductIDs, nil
+}
+
+// bookLevels returns the level of order book to keep for each product, 2 for
+// those configured as level 2 and 3 for the rest
+func (s *Server) bookLevels(productIDs []string) (map[string]int, error) {
+	levels := make(map[string]int, len(productIDs))
+	for _, p := range productIDs {
+		levels[p] = 3
+	}
+	for _, p := range s.config.Level2ProductIDs {
+		if _, ok := levels[p]; !ok {
+			return nil, fmt.Errorf("%s is configured as level 2 but isn't quoted", p)
+		}
+		levels[p] = 2
+	}
+	return levels, nil
+}
+
+// feedChannels returns the websocket channels the books need, the full channel
+// for level 3 books and the level2 channel for level 2 books. when every book
+// is level 3 no channels are given, which subscribes to the full channel
+func feedChannels(productIDs []string, levels map[string]int) []gdax.Channel {
+	full := gdax.Channel{Name: gdax.FullChannel}
+	level2 := gdax.Channel{Name: gdax.Level2Channel}
+	for _, p := range productIDs {
+		if levels[p] == 2 {
+			level2.ProductIDs = append(level2.ProductIDs, p)
+		} else {
+			full.ProductIDs = append(full.ProductIDs, p)
+		}
+	}
+
+	if len(level2.ProductIDs) == 0 {
+		return nil
+	}
+	if len(full.ProductIDs) == 0 {
+		return []gdax.Channel{level2}
+	}
+	return []gdax.Channel{full, level2}
+}
+
+// connectReplay starts order books for the configured products, or every
+// recorded product if none are configured, that are driven by a recording,
+// then starts playing it back
+func (s *Server) connectReplay() error {
+	replay, err := gdax.OpenReplay(s.config.ReplayFile, s.config.ReplayOptions)
+	if err != nil {
+		return fmt.Errorf("Error opening replay file\n%s", err)
+	}
+	s.feed = replay
+	s.reportFeedErrors(replay)
+
+	registry, err := replay.Registry()
+	if err != nil {
+		return fmt.Errorf("Error opening replay file\n%s", err)
+	}
+	s.registry = registry
+
+	productIDs := s.config.ProductIDs
+	if len(productIDs) == 0 {
+		productIDs = replay.ProductIDs()
+	}
+
+	levels, err := s.bookLevels(productIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range productIDs {
+		lob, err := replay.NewLiveOrderBook(p, levels[p], s.done)
+		if err != nil {
+			return fmt.Errorf("Error while establishing order books\n%s", err)
+		}
+		s.addLiveOrderBook(p, lob)
+	}
+
+	replay.Play()
+	return nil
+}
+
+// reportFeedErrors prints and counts the Error messages GDAX sends on the
+// feed. they aren't about any one product, so they're reported once here
+// rather than by every book
+func (s *Server) reportFeedErrors(source gdax.MessageSource) {
+	messages := make(chan gdax.Message)
+	source.Subscribe(messages, gdax.Filter{Types: []string{gdax.ErrorMessage}})
+	go func() {
+		for m := range messages {
+			var message string
+			if e, ok := m.(gdax.Error); ok {
+				message = e.Message
+			}
+			s.metrics.feedErrors.Inc(message)
+			fmt.Fprintf(os.Stderr, "Error from the feed: %s\n", m)
+		}
+	}()
+}
+
+func (s *Server) addLiveOrderBook(productID string, lob *gdax.LiveOrderBook) {
+	lob.SetGapTolerance(s.config.GapTolerance)
+	s.AddOrderBook(productID, lob)
+	go func() {
+		for err := range lob.ErrorChan {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+}
+
+// ListenAndServe listens on the configured port and serves requests until
+// the server is shut down
+func (s *Server) ListenAndServe() error {
+	log.Printf("Listening on port %s\n", s.config.ListenPort)
+	return s.http.ListenAndServe()
+}
+
+// Shutdown stops the server, waiting for in-flight requests to finish, then
+// stops the order books and closes the feed. An error is returned if requests
+// couldn't be drained before ctx expired. It's safe to call more than once
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+
+	s.shutdownOnce.Do(func() {
+		close(s.done)
+		s.cancel()
+		for _, q := range s.books() {
+			if lob, ok := q.(*gdax.LiveOrderBook); ok {
+				lob.Close()
+			}
+		}
+		if s.feed != nil {
+			s.feed.Close()
+		}
+		if s.recorder != nil {
+			s.recorder.Close()
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+	"github.com/akb/quoted/gdax/gdaxtest"
+)
+
+// slowQuoter holds each quote until it's released
+type slowQuoter struct {
+	*fakeQuoter
+	quoting, release chan struct{}
+}
+
+func (q slowQuoter) Quote(action string, amount gdax.Decimal, funds bool) (gdax.Quote, error) {
+	close(q.quoting)
+	<-q.release
+	return q.fakeQuoter.Quote(action, amount, funds)
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	fake := gdaxtest.NewServer(integrationBooks, integrationScript)
+	defer fake.Close()
+	t.Setenv("GDAX_API_URL", fake.URL)
+	t.Setenv("GDAX_WEBSOCKET_URL", fake.WebsocketURL())
+	t.Setenv("GDAX_PRODUCT_IDS", "BTC-USD")
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	server := NewServer(config)
+	if err := server.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	lob := server.orderbook("BTC-USD").(*gdax.LiveOrderBook)
+
+	slow := slowQuoter{
+		&fakeQuoter{size: "2", funds: "100.02", running: true},
+		make(chan struct{}), make(chan struct{}),
+	}
+	server.AddOrderBook("LTC-USD", slow)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	go server.http.Serve(listener)
+
+	statuses := make(chan int, 1)
+	go func() {
+		response, err := http.Post("http://"+listener.Addr().String()+"/quote", "application/json",
+			strings.NewReader(`{"action":"buy","base_currency":"LTC","quote_currency":"USD","amount":"2"}`))
+		if err != nil {
+			t.Errorf("%s", err)
+			statuses <- 0
+			return
+		}
+		response.Body.Close()
+		statuses <- response.StatusCode
+	}()
+	<-slow.quoting
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before the request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(slow.release)
+
+	if status := <-statuses; status != http.StatusOK {
+		t.Errorf("expected the request in flight to finish with 200, got %d", status)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("%s", err)
+	}
+	if state := lob.Status().State; state != "closed" {
+		t.Errorf("expected the book to be closed, it's %s", state)
+	}
+
+	// a second shutdown, say from a signal after an error, is harmless
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
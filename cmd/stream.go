@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	quoteEvent = "quote"
+	errorEvent = "error"
+)
+
+// StreamEvent is sent on quote streams. Its Type is "quote", with the latest
+// Quote, or "error" with a Message. Websocket streams send StreamEvents as
+// they are. Server-sent event streams name each event by its Type, with the
+// quote, or an object holding the message, as its data.
+type StreamEvent struct {
+	Type    string         `json:"type"`
+	Quote   *QuoteResponse `json:"quote,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+// GET /quote/stream
+//
+// Streams quotes for a QuoteRequest, sending a new quote whenever the order
+// books it's made from change it, but no more often than the configured
+// stream interval. Websocket clients send the QuoteRequest as their first
+// message, otherwise the request is read from query parameters named like its
+// JSON fields and the quotes are sent as server-sent events. Streams end,
+// after an error event, when a book becomes unavailable, such as when it's
+// reset.
+func (s *Server) handleQuoteStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	detail, err := parseDetail(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Handler(func(ws *websocket.Conn) {
+			s.streamWebsocket(ws, detail)
+		}).ServeHTTP(w, r)
+		return
+	}
+
+	s.streamEvents(w, r, detail)
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, detail bool) {
+	query := r.URL.Query()
+	q := QuoteRequest{
+		Action:         query.Get("action"),
+		BaseCurrency:   query.Get("base_currency"),
+		QuoteCurrency:  query.Get("quote_currency"),
+		Amount:         query.Get("amount"),
+		AmountCurrency: query.Get("amount_currency"),
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	started := false
+	err := s.streamQuotes(r.Context(), q, detail, func(e StreamEvent) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		var data []byte
+		if e.Quote != nil {
+			data, _ = json.Marshal(e.Quote)
+		} else {
+			data, _ = json.Marshal(struct {
+				Message string `json:"message"`
+			}{e.Message})
+		}
+
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+
+	// the first quote failed, so nothing has been sent yet
+	if e, ok := err.(*quoteError); ok {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, e.status, e.message)
+	}
+}
+
+func (s *Server) streamWebsocket(ws *websocket.Conn, detail bool) {
+	defer ws.Close()
+
+	var q QuoteRequest
+	if err := websocket.JSON.Receive(ws, &q); err != nil {
+		websocket.JSON.Send(ws, StreamEvent{Type: errorEvent, Message: err.Error()})
+		return
+	}
+
+	// a hijacked connection's request isn't cancelled when the client hangs
+	// up, that's only noticed by reading
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, err := ws.Read(make([]byte, 512)); err != nil {
+				return
+			}
+		}
+	}()
+
+	err := s.streamQuotes(ctx, q, detail, func(e StreamEvent) error {
+		return websocket.JSON.Send(ws, e)
+	})
+	if err != nil {
+		websocket.JSON.Send(ws, StreamEvent{Type: errorEvent, Message: err.Error()})
+	}
+}
+
+// streamQuotes sends quotes for q until ctx is done, the server shuts down or
+// one of the books quoted from becomes unavailable. Quotes are only sent when
+// they differ from the last one sent, other than in when the books were read. If the first quote can't be made its
+// error is returned and nothing is sent
+func (s *Server) streamQuotes(
+	ctx context.Context, q QuoteRequest, detail bool, send func(StreamEvent) error,
+) error {
+	// the books on every route the quote could be walked through
+	from, to := q.exchange()
+	var books []Quoter
+	seen := map[string]bool{}
+	for _, r := range s.findRoutes(from, to) {
+		for _, l := range r {
+			if b := s.orderbook(l.product.ID); b != nil && !seen[l.product.ID] {
+				seen[l.product.ID] = true
+				books = append(books, b)
+			}
+		}
+	}
+
+	var last []byte
+	for first := true; ; first = false {
+		// waiting on the books starts before quoting, so no change is missed
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.closing)},
+		}
+		for _, b := range books {
+			cases = append(cases, reflect.SelectCase{
+				Dir: reflect.SelectRecv, Chan: reflect.ValueOf(b.Changed()),
+			})
+		}
+		quoted := time.Now()
+
+		event := StreamEvent{Type: quoteEvent}
+		// recomputed quotes aren't quote requests, so they're counted apart
+		response, _, err := s.quote(q, detail, s.metrics.streamQuotes)
+		if err != nil && first {
+			return err
+		} else if err != nil {
+			event = StreamEvent{Type: errorEvent, Message: err.Error()}
+		} else {
+			event.Quote = &response
+		}
+
+		buf := dedupeKey(event)
+		if !bytes.Equal(buf, last) {
+			if err := send(event); err != nil {
+				return nil
+			}
+			last = buf
+		}
+
+		if e, ok := err.(*quoteError); ok && e.status == http.StatusServiceUnavailable {
+			return nil
+		}
+
+		if chosen, _, _ := reflect.Select(cases); chosen < 2 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.closing:
+			return nil
+		case <-time.After(time.Until(quoted.Add(s.config.StreamInterval))):
+		}
+	}
+}
+
+// dedupeKey encodes a stream event for comparing with the last one sent,
+// without the sequence and time of the books its quote was read from. they
+// change with every feed message, even when the quote doesn't
+func dedupeKey(event StreamEvent) []byte {
+	if event.Quote != nil {
+		q := *event.Quote
+		q.Legs = make([]QuoteLeg, len(event.Quote.Legs))
+		for i, l := range event.Quote.Legs {
+			if l.Detail != nil {
+				detail := *l.Detail
+				detail.Sequence, detail.Time = 0, time.Time{}
+				l.Detail = &detail
+			}
+			q.Legs[i] = l
+		}
+		event.Quote = &q
+	}
+
+	buf, _ := json.Marshal(event)
+	return buf
+}
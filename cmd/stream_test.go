@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/akb/quoted/gdax"
+)
+
+const streamQuery = "action=buy&base_currency=LTC&quote_currency=USD&amount=2"
+
+func startStreamServer(t *testing.T) (*fakeQuoter, *httptest.Server) {
+	book := &fakeQuoter{size: "2", funds: "100.02", running: true}
+	s := newTestServer()
+	s.AddOrderBook("LTC-USD", book)
+
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return book, ts
+}
+
+// readEvent reads a server-sent event, returning its name and data
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	book, ts := startStreamServer(t)
+
+	response, err := http.Get(ts.URL + "/quote/stream?" + streamQuery)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer response.Body.Close()
+	if ct := response.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", response.StatusCode, ct)
+	}
+	r := bufio.NewReader(response.Body)
+
+	price := func() string {
+		event, data := readEvent(t, r)
+		var q QuoteResponse
+		if event != quoteEvent || json.Unmarshal([]byte(data), &q) != nil {
+			t.Fatalf("expected a quote event, got %s: %s", event, data)
+		}
+		return q.Price
+	}
+
+	if p := price(); p != "50.01" {
+		t.Errorf("expected the first quote at 50.01, got %s", p)
+	}
+
+	// a change that doesn't move the quote isn't sent
+	book.update(func(f *fakeQuoter) {})
+	book.update(func(f *fakeQuoter) { f.funds = "100.04" })
+	if p := price(); p != "50.02" {
+		t.Errorf("expected the next quote at 50.02, got %s", p)
+	}
+
+	book.update(func(f *fakeQuoter) { f.running, f.err = false, gdax.ErrBookUnavailable })
+	if event, _ := readEvent(t, r); event != errorEvent {
+		t.Errorf("expected an error event when the book resets, got %s", event)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Errorf("the stream should end when the book resets")
+	}
+
+	// streamed quotes aren't counted as quote requests
+	metrics, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer metrics.Body.Close()
+	var body strings.Builder
+	bufio.NewReader(metrics.Body).WriteTo(&body)
+	if !strings.Contains(body.String(), "quoted_stream_quotes_total{") ||
+		strings.Contains(body.String(), "quoted_quotes_total{") {
+		t.Errorf("expected only stream quotes to be counted, got\n%s", body.String())
+	}
+}
+
+func TestStreamEventsWithDetail(t *testing.T) {
+	book, ts := startStreamServer(t)
+
+	response, err := http.Get(ts.URL + "/quote/stream?detail=true&" + streamQuery)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer response.Body.Close()
+	r := bufio.NewReader(response.Body)
+
+	quote := func() QuoteResponse {
+		event, data := readEvent(t, r)
+		var q QuoteResponse
+		if event != quoteEvent || json.Unmarshal([]byte(data), &q) != nil || len(q.Legs) != 1 {
+			t.Fatalf("expected a quote event with a leg, got %s: %s", event, data)
+		}
+		return q
+	}
+	quote()
+
+	// the book moving on without the quote changing isn't sent
+	book.update(func(f *fakeQuoter) { f.sequence, f.at = 2, time.Now() })
+	time.Sleep(50 * time.Millisecond) // long enough for the stream to requote
+	book.update(func(f *fakeQuoter) { f.sequence, f.funds = 3, "100.04" })
+	if q := quote(); q.Price != "50.02" || q.Legs[0].Detail.Sequence != 3 {
+		t.Errorf("expected the quote at 50.02 from sequence 3, got %s from %d",
+			q.Price, q.Legs[0].Detail.Sequence)
+	}
+}
+
+func TestStreamEventsBadRequest(t *testing.T) {
+	_, ts := startStreamServer(t)
+
+	response, err := http.Get(ts.URL + "/quote/stream?action=waffle")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", response.StatusCode)
+	}
+}
+
+func TestStreamWebsocket(t *testing.T) {
+	book, ts := startStreamServer(t)
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/quote/stream"
+	ws, err := websocket.Dial(url, "", "http://localhost")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer ws.Close()
+
+	if err := websocket.JSON.Send(ws, QuoteRequest{"buy", "LTC", "USD", "2", ""}); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	receive := func() StreamEvent {
+		var e StreamEvent
+		if err := websocket.JSON.Receive(ws, &e); err != nil {
+			t.Fatalf("%s", err)
+		}
+		return e
+	}
+
+	if e := receive(); e.Quote == nil || e.Quote.Price != "50.01" {
+		t.Errorf("expected a quote at 50.01, got %+v", e)
+	}
+
+	book.update(func(f *fakeQuoter) { f.funds = "100.04" })
+	if e := receive(); e.Quote == nil || e.Quote.Price != "50.02" {
+		t.Errorf("expected a quote at 50.02, got %+v", e)
+	}
+
+	book.update(func(f *fakeQuoter) { f.running, f.err = false, gdax.ErrBookUnavailable })
+	if e := receive(); e.Type != errorEvent {
+		t.Errorf("expected an error when the book resets, got %+v", e)
+	}
+	var e StreamEvent
+	if err := websocket.JSON.Receive(ws, &e); err == nil {
+		t.Errorf("the stream should be closed when the book resets, got %+v", e)
+	}
+}
@@ -0,0 +1,51 @@
+package gdax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const accountsPath = "/accounts"
+
+// Account is the balance of one currency in a profile, as listed by /accounts.
+// Hold is the part of Balance reserved for open orders, Available the rest.
+type Account struct {
+	ID        string  `json:"id"`
+	ProfileID string  `json:"profile_id"`
+	Currency  string  `json:"currency"`
+	Balance   Decimal `json:"balance"`
+	Available Decimal `json:"available"`
+	Hold      Decimal `json:"hold"`
+}
+
+// GetAccounts lists the accounts of the credentials' profile
+func (a API) GetAccounts(c *http.Client, ctx context.Context) ([]Account, error) {
+	body, err := a.Request(c, ctx, http.MethodGet, accountsPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	if err := json.Unmarshal(body, &accounts); err != nil {
+		return nil, fmt.Errorf("Error decoding accounts: %s", err)
+	}
+	return accounts, nil
+}
+
+// GetAccount fetches a single account by its ID
+func (a API) GetAccount(c *http.Client, ctx context.Context, id string) (*Account, error) {
+	path := accountsPath + "/" + url.PathEscape(id)
+	body, err := a.Request(c, ctx, http.MethodGet, path, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var account Account
+	if err := json.Unmarshal(body, &account); err != nil {
+		return nil, fmt.Errorf("Error decoding account: %s", err)
+	}
+	return &account, nil
+}
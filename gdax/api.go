@@ -6,62 +6,28 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 const orderBookPath = "/products/%s/book?level=%d"
 
-var ProductIDs = []string{
-	"BTC-USD", "ETH-USD", "LTC-USD",
-	"ETH-BTC", "LTC-BTC",
-}
-
-func CurrencyPrecision(currency string) int {
-	switch currency {
-	case "USD", "EUR", "GBP":
-		return 2
-	case "BTC", "LTC", "ETH":
-		return 8
-	default:
-		return -1
-	}
-}
-
-func ProductIDForCurrencyPair(a, b string) (productID string) {
-	switch a {
-	case "USD", "EUR":
-		if b == "BTC" || b == "ETH" || b == "LTC" {
-			productID = fmt.Sprintf("%s-%s", b, a)
-		}
-	case "GBP":
-		if b == "BTC" {
-			productID = "BTC-GBP"
-		}
-	case "BTC":
-		if b == "USD" || b == "GBP" || b == "EUR" {
-			productID = fmt.Sprintf("%s-%s", a, b)
-		} else if b == "LTC" || b == "ETH" {
-			productID = fmt.Sprintf("%s-%s", b, a)
-		}
-	case "ETH", "LTC":
-		if b == "USD" || b == "EUR" || b == "BTC" {
-			productID = fmt.Sprintf("%s-%s", a, b)
-		}
-	}
-	return
+// API is a client for the GDAX REST API. Requests are signed with Credentials
+// when they're set, which the authenticated endpoints require.
+type API struct {
+	URL         string
+	Credentials *Credentials
 }
 
-func IsValidProductID(productID string) (valid bool) {
-	for _, id := range ProductIDs {
-		if productID == id {
-			valid = true
-		}
-	}
-	return
+// APIError is returned for requests GDAX responds to with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
 }
 
-type API struct {
-	URL string
+func (e *APIError) Error() string {
+	return fmt.Sprintf("GDAX API error %d: %s", e.StatusCode, e.Message)
 }
 
 func NewAPI(url string) (*API, error) {
@@ -69,54 +35,97 @@ func NewAPI(url string) (*API, error) {
 		return nil, fmt.Errorf("Missing GDAX REST API URL\n")
 	}
 
-	return &API{url}, nil
+	return &API{URL: url}, nil
 }
 
+// Request sends a request to the API and returns the body of its response. If
+// the response has an error status an *APIError is returned instead.
 func (a API) Request(
 	c *http.Client, ctx context.Context, method, path, message string,
 ) ([]byte, error) {
+	body, _, err := a.request(c, ctx, method, path, message)
+	return body, err
+}
+
+// GetPages GETs the pages of a paginated list, following the CB-AFTER cursor
+// GDAX sends with each page. The body of each page is passed to add, which
+// returns how many items it kept, and paging stops at the first page add
+// keeps nothing from.
+func (a API) GetPages(
+	c *http.Client, ctx context.Context, path string, query url.Values,
+	add func(body []byte) (int, error),
+) error {
+	for {
+		p := path
+		if len(query) > 0 {
+			p += "?" + query.Encode()
+		}
+		body, header, err := a.request(c, ctx, http.MethodGet, p, "")
+		if err != nil {
+			return err
+		}
+
+		n, err := add(body)
+		if err != nil {
+			return err
+		}
+		after := header.Get("CB-AFTER")
+		if n == 0 || len(after) == 0 || after == query.Get("after") {
+			return nil
+		}
+		query.Set("after", after)
+	}
+}
+
+// request is Request, also returning the response's headers
+func (a API) request(
+	c *http.Client, ctx context.Context, method, path, message string,
+) ([]byte, http.Header, error) {
 	request, err := http.NewRequest(method, a.URL+path, strings.NewReader(message))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	request = request.WithContext(ctx)
 	request.Header.Add("Accept", "application/json")
 	request.Header.Add("Content-Type", "application/json; charset=utf-8")
+	if a.Credentials != nil {
+		if err := a.Credentials.authenticate(request, message, time.Now()); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	response, err := c.Do(request)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer response.Body.Close()
 
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return body, err
+		e := APIError{StatusCode: response.StatusCode}
+		var m struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &m) == nil && len(m.Message) > 0 {
+			e.Message = m.Message
+		} else {
+			e.Message = http.StatusText(response.StatusCode)
+		}
+		return nil, nil, &e
 	}
 
-	return body, nil
+	return body, response.Header, nil
 }
 
 func (a API) GetOrderBook(
 	c *http.Client, ctx context.Context, productID string, level int,
 ) (*OrderBook, error) {
-
-	if !IsValidProductID(productID) {
-		return nil, fmt.Errorf("%s is not a valid product ID", productID)
-	}
-
-	if level < 1 || level > 3 {
-		return nil, fmt.Errorf("Level must be 1, 2, or 3")
-	}
-
-	path := fmt.Sprintf(orderBookPath, productID, level)
-
-	body, err := a.Request(c, ctx, http.MethodGet, path, "")
+	body, err := a.GetOrderBookJSON(c, ctx, productID, level)
 	if err != nil {
 		return nil, err
 	}
@@ -128,3 +137,17 @@ func (a API) GetOrderBook(
 
 	return &ob, nil
 }
+
+// GetOrderBookJSON fetches an order book snapshot without parsing it
+func (a API) GetOrderBookJSON(
+	c *http.Client, ctx context.Context, productID string, level int,
+) ([]byte, error) {
+
+	if level < 1 || level > 3 {
+		return nil, fmt.Errorf("Level must be 1, 2, or 3")
+	}
+
+	path := fmt.Sprintf(orderBookPath, productID, level)
+
+	return a.Request(c, ctx, http.MethodGet, path, "")
+}
@@ -0,0 +1,80 @@
+package gdax
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Credentials are an API key for the authenticated parts of the GDAX API.
+// Secret is base64 encoded, the way GDAX issues it.
+type Credentials struct {
+	Key        string `json:"key"`
+	Secret     string `json:"secret"`
+	Passphrase string `json:"passphrase"`
+}
+
+// LoadCredentials reads credentials from a JSON file with key, secret and
+// passphrase fields
+func LoadCredentials(path string) (*Credentials, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Credentials
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks that every part of the credentials is present and that the
+// secret can be decoded
+func (c Credentials) Validate() error {
+	if len(c.Key) == 0 || len(c.Secret) == 0 || len(c.Passphrase) == 0 {
+		return fmt.Errorf("Credentials need a key, secret and passphrase")
+	}
+	if _, err := base64.StdEncoding.DecodeString(c.Secret); err != nil {
+		return fmt.Errorf("Credentials secret isn't base64: %s", err)
+	}
+	return nil
+}
+
+// Sign returns the CB-ACCESS-SIGN signature of a request: the base64 encoded
+// HMAC-SHA256, keyed by the decoded secret, of the timestamp, method, path
+// (including any query) and body concatenated
+func (c Credentials) Sign(timestamp, method, path, body string) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(c.Secret)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + method + path + body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// authenticate adds the CB-ACCESS headers that sign request as of now
+func (c Credentials) authenticate(r *http.Request, body string, now time.Time) error {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature, err := c.Sign(timestamp, r.Method, r.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("CB-ACCESS-KEY", c.Key)
+	r.Header.Set("CB-ACCESS-SIGN", signature)
+	r.Header.Set("CB-ACCESS-TIMESTAMP", timestamp)
+	r.Header.Set("CB-ACCESS-PASSPHRASE", c.Passphrase)
+	return nil
+}
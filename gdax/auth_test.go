@@ -0,0 +1,167 @@
+package gdax_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+	"github.com/akb/quoted/gdax/gdaxtest"
+)
+
+var credentials = gdax.Credentials{
+	Key:        "key",
+	Secret:     "bm90IGEgcmVhbCBzZWNyZXQ=",
+	Passphrase: "passphrase",
+}
+
+func TestSign(t *testing.T) {
+	signature, err := credentials.Sign("1500000000", "GET", "/orders?product_id=BTC-USD", "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if expected := "x1jqUFHyveSqOTmCaZzBJfsxBO46Ah53czm7brdupew="; signature != expected {
+		t.Errorf("expected signature %s, got %s", expected, signature)
+	}
+}
+
+func startAuthenticatedServer() *gdaxtest.Server {
+	ts := gdaxtest.NewServer(map[string]gdaxtest.Book{}, nil)
+	ts.Credentials = &credentials
+
+	balance, _ := gdax.NewDecimal("1.5")
+	ts.Accounts = []gdax.Account{
+		{ID: "account-btc", Currency: "BTC", Balance: balance, Available: balance},
+		{ID: "account-usd", Currency: "USD"},
+	}
+	ts.Fills = []gdax.OrderFill{
+		{TradeID: 1, ProductID: "BTC-USD", OrderID: "order-a", Side: gdax.BuyAction},
+		{TradeID: 2, ProductID: "ETH-USD", OrderID: "order-b", Side: gdax.SellAction},
+	}
+	ts.Orders = []gdax.Order{
+		{ID: "order-c", ProductID: "BTC-USD", Side: gdax.SellAction, Status: "open"},
+		{ID: "order-d", ProductID: "BTC-USD", Side: gdax.BuyAction, Status: "done"},
+		{ID: "order-e", ProductID: "ETH-USD", Side: gdax.BuyAction, Status: "open"},
+	}
+	return ts
+}
+
+func TestAuthenticatedRequests(t *testing.T) {
+	ts := startAuthenticatedServer()
+	defer ts.Close()
+
+	c, ctx := http.DefaultClient, context.Background()
+	api := gdax.API{URL: ts.URL, Credentials: &credentials}
+
+	accounts, err := api.GetAccounts(c, ctx)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(accounts) != 2 || accounts[0].Balance.String() != "1.5" {
+		t.Errorf("expected both accounts, got %+v", accounts)
+	}
+
+	account, err := api.GetAccount(c, ctx, "account-usd")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if account.Currency != "USD" {
+		t.Errorf("expected the USD account, got %+v", account)
+	}
+
+	fills, err := api.GetFills(c, ctx, "", "ETH-USD", time.Time{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(fills) != 1 || fills[0].TradeID != 2 {
+		t.Errorf("expected the ETH-USD fill, got %+v", fills)
+	}
+
+	orders, err := api.GetOrders(c, ctx, "BTC-USD")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(orders) != 1 || orders[0].ID != "order-c" {
+		t.Errorf("expected the open BTC-USD order, got %+v", orders)
+	}
+
+	if o, err := api.GetOrder(c, ctx, "order-d"); err != nil || o.Status != "done" {
+		t.Errorf("expected the done order, got %+v, %v", o, err)
+	}
+}
+
+func TestUnauthenticatedRequests(t *testing.T) {
+	ts := startAuthenticatedServer()
+	defer ts.Close()
+
+	wrong := credentials
+	wrong.Secret = "d3Jvbmc="
+	for _, c := range []struct {
+		credentials *gdax.Credentials
+		message     string
+	}{
+		{nil, "Invalid API Key"},
+		{&wrong, "invalid signature"},
+	} {
+		api := gdax.API{URL: ts.URL, Credentials: c.credentials}
+		_, err := api.GetAccounts(http.DefaultClient, context.Background())
+		e, ok := err.(*gdax.APIError)
+		if !ok {
+			t.Errorf("expected an API error, got %v", err)
+			continue
+		}
+		if e.StatusCode != http.StatusUnauthorized || e.Message != c.message {
+			t.Errorf("expected 401 %s, got %d %s", c.message, e.StatusCode, e.Message)
+		}
+	}
+}
+
+func TestPaginatedRequests(t *testing.T) {
+	ts := startAuthenticatedServer()
+	defer ts.Close()
+	ts.PageSize = 2
+
+	// fills are listed newest first, an hour apart
+	now := time.Now()
+	ts.Orders, ts.Fills = nil, nil
+	for i := 0; i < 5; i++ {
+		ts.Orders = append(ts.Orders, gdax.Order{
+			ID: fmt.Sprintf("order-%d", i), ProductID: "BTC-USD", Status: "open",
+		})
+		ts.Fills = append(ts.Fills, gdax.OrderFill{
+			TradeID: int64(10 - i), ProductID: "BTC-USD",
+			CreatedAt: now.Add(-time.Duration(i) * time.Hour),
+		})
+	}
+
+	c, ctx := http.DefaultClient, context.Background()
+	api := gdax.API{URL: ts.URL, Credentials: &credentials}
+
+	orders, err := api.GetOrders(c, ctx, "BTC-USD")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(orders) != 5 || orders[0].ID != "order-0" || orders[4].ID != "order-4" {
+		t.Errorf("expected every page of orders, got %+v", orders)
+	}
+
+	fills, err := api.GetFills(c, ctx, "", "", time.Time{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(fills) != 5 || fills[4].TradeID != 6 {
+		t.Errorf("expected every page of fills, got %+v", fills)
+	}
+
+	// paging stops at the page that reaches back past since
+	ts.Fills = append(ts.Fills, gdax.OrderFill{TradeID: 1, CreatedAt: now.Add(-time.Hour)})
+	fills, err = api.GetFills(c, ctx, "", "", now.Add(-150*time.Minute))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(fills) != 3 || fills[2].TradeID != 8 {
+		t.Errorf("expected the fills since 2.5 hours ago, got %+v", fills)
+	}
+}
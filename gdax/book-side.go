@@ -0,0 +1,211 @@
+package gdax
+
+// the tallest a price level can be in a BookSide's skiplist. with a 1 in 4
+// chance of each extra level, 16 is enough for billions of price levels
+const maxLevelHeight = 16
+
+// priceLevel holds the orders at a single price, oldest first, and is a node
+// in a BookSide's skiplist
+type priceLevel struct {
+	price  Decimal
+	first  *OrderBookEntry
+	last   *OrderBookEntry
+	orders int
+
+	// next holds the following level at each height of the skiplist
+	next []*priceLevel
+}
+
+// BookSide holds one side of an order book. Orders are grouped into price
+// levels kept in a skiplist ordered best price first, the highest bid or the
+// lowest ask, and orders within a level are queued in the order they arrived.
+// Adding an order at an existing price and removing one that leaves other
+// orders at its price take constant time. Adding or removing a level takes
+// time logarithmic in the number of levels.
+type BookSide struct {
+	// bids are ordered by descending price, asks by ascending
+	descending bool
+
+	head   priceLevel
+	height int
+	levels int
+	orders int
+	seed   uint32
+}
+
+func newBookSide(side string) *BookSide {
+	return &BookSide{
+		descending: side == BidSide,
+		head:       priceLevel{next: make([]*priceLevel, maxLevelHeight)},
+		height:     1,
+		seed:       2463534242,
+	}
+}
+
+// Len returns the number of orders on the side
+func (s *BookSide) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.orders
+}
+
+// Levels returns the number of distinct prices on the side
+func (s *BookSide) Levels() int {
+	if s == nil {
+		return 0
+	}
+	return s.levels
+}
+
+// Best returns the oldest order at the best price, or nil if the side is empty
+func (s *BookSide) Best() *OrderBookEntry {
+	if s == nil || s.head.next[0] == nil {
+		return nil
+	}
+	return s.head.next[0].first
+}
+
+// Walk calls fn for each order on the side, best price first and oldest first
+// within a price, until fn returns false
+func (s *BookSide) Walk(fn func(e *OrderBookEntry) bool) {
+	if s == nil {
+		return
+	}
+	for l := s.head.next[0]; l != nil; l = l.next[0] {
+		for e := l.first; e != nil; e = e.next {
+			if !fn(e) {
+				return
+			}
+		}
+	}
+}
+
+// Entries returns every order on the side in the order Walk visits them
+func (s *BookSide) Entries() []*OrderBookEntry {
+	entries := make([]*OrderBookEntry, 0, s.Len())
+	s.Walk(func(e *OrderBookEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return entries
+}
+
+// push adds an order to the back of the queue at its price
+func (s *BookSide) push(e *OrderBookEntry) {
+	l := s.level(e.Price)
+	e.level, e.prev, e.next = l, l.last, nil
+	if l.last != nil {
+		l.last.next = e
+	} else {
+		l.first = e
+	}
+	l.last = e
+	l.orders++
+	s.orders++
+}
+
+// remove takes an order out of its queue, removing its price level if it was
+// the only order at that price
+func (s *BookSide) remove(e *OrderBookEntry) {
+	l := e.level
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		l.first = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		l.last = e.prev
+	}
+	e.level, e.prev, e.next = nil, nil, nil
+	l.orders--
+	s.orders--
+
+	if l.orders == 0 {
+		s.removeLevel(l)
+	}
+}
+
+// before reports whether price a is better than price b for this side
+func (s *BookSide) before(a, b Decimal) bool {
+	if s.descending {
+		return a.Cmp(b) > 0
+	}
+	return a.Cmp(b) < 0
+}
+
+// search finds the level at price, if there is one. When update is given it is
+// filled with the last level before price at each height
+func (s *BookSide) search(price Decimal, update []*priceLevel) *priceLevel {
+	x := &s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.before(x.next[i].price, price) {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+
+	if x = x.next[0]; x != nil && x.price.Cmp(price) == 0 {
+		return x
+	}
+	return nil
+}
+
+// level returns the level at price, adding it if there isn't one
+func (s *BookSide) level(price Decimal) *priceLevel {
+	var update [maxLevelHeight]*priceLevel
+	if l := s.search(price, update[:]); l != nil {
+		return l
+	}
+
+	height := s.randomHeight()
+	for i := s.height; i < height; i++ {
+		update[i] = &s.head
+	}
+	if height > s.height {
+		s.height = height
+	}
+
+	l := &priceLevel{price: price, next: make([]*priceLevel, height)}
+	for i := 0; i < height; i++ {
+		l.next[i] = update[i].next[i]
+		update[i].next[i] = l
+	}
+	s.levels++
+	return l
+}
+
+func (s *BookSide) removeLevel(l *priceLevel) {
+	var update [maxLevelHeight]*priceLevel
+	s.search(l.price, update[:])
+	for i := range l.next {
+		if update[i].next[i] == l {
+			update[i].next[i] = l.next[i]
+		}
+	}
+	for s.height > 1 && s.head.next[s.height-1] == nil {
+		s.height--
+	}
+	s.levels--
+}
+
+// randomHeight picks the height of a new level, each extra level having a 1 in
+// 4 chance. a xorshift generator is used so that sides don't contend on the
+// global math/rand lock, and so that books are built the same way every time
+func (s *BookSide) randomHeight() int {
+	height := 1
+	for height < maxLevelHeight {
+		s.seed ^= s.seed << 13
+		s.seed ^= s.seed >> 17
+		s.seed ^= s.seed << 5
+		if s.seed&3 != 0 {
+			break
+		}
+		height++
+	}
+	return height
+}
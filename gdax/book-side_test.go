@@ -0,0 +1,208 @@
+package gdax
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestBookSideFIFO(t *testing.T) {
+	ob := NewOrderBook()
+	ob.Insert(BidSide, d("10"), d("1"), "first")
+	ob.Insert(BidSide, d("9"), d("1"), "worse")
+	ob.Insert(BidSide, d("10"), d("1"), "second")
+	ob.Insert(BidSide, d("10"), d("1"), "third")
+
+	if ob.Bids.Levels() != 2 {
+		t.Errorf("expected 2 price levels, got %d", ob.Bids.Levels())
+	}
+
+	var ids []string
+	for _, e := range ob.Bids.Entries() {
+		ids = append(ids, e.OrderID)
+	}
+	if fmt.Sprint(ids) != "[first second third worse]" {
+		t.Errorf("orders at a price should be queued oldest first, got %v", ids)
+	}
+
+	ob.Delete("first")
+	ob.Delete("second")
+	ob.Delete("third")
+	if ob.Bids.Levels() != 1 || ob.Bids.Best().OrderID != "worse" {
+		t.Errorf("emptied price levels should be removed")
+	}
+}
+
+// TestBookSideRandom checks the book against a sorted slice through a long run
+// of random inserts and deletes
+func TestBookSideRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ob := NewOrderBook()
+	var model []*OrderBookEntry
+
+	for i := 0; i < 20000; i++ {
+		if len(model) > 0 && r.Intn(3) == 0 {
+			j := r.Intn(len(model))
+			ob.Delete(model[j].OrderID)
+			model = append(model[:j], model[j+1:]...)
+			continue
+		}
+
+		id := strconv.Itoa(i)
+		price := DecimalFromInt(int64(r.Intn(200)))
+		ob.Insert(AskSide, price, d("1"), id)
+		model = append(model, &OrderBookEntry{Price: price, OrderID: id})
+	}
+
+	// a stable sort keeps orders at the same price in arrival order
+	sort.SliceStable(model, func(i, j int) bool {
+		return model[i].Price.Cmp(model[j].Price) < 0
+	})
+
+	entries := ob.Asks.Entries()
+	if len(entries) != len(model) || ob.Asks.Len() != len(model) {
+		t.Fatalf("expected %d orders, got %d", len(model), len(entries))
+	}
+	for i := range model {
+		if entries[i].OrderID != model[i].OrderID {
+			t.Fatalf("order %d: expected %s, got %s",
+				i, model[i].OrderID, entries[i].OrderID)
+		}
+	}
+}
+
+// sliceBook is one side of an order book kept as a sorted slice, the way
+// OrderBook was implemented before BookSide, for comparison in benchmarks
+type sliceBook struct {
+	entries []*OrderBookEntry
+	ids     map[string]*OrderBookEntry
+}
+
+// newSliceBook builds a sliceBook of bids all at once, inserting tens of
+// thousands of orders one at a time takes minutes
+func newSliceBook(orders []OrderBookEntry) *sliceBook {
+	b := &sliceBook{ids: map[string]*OrderBookEntry{}}
+	for i := range orders {
+		entry := orders[i]
+		b.entries = append(b.entries, &entry)
+		b.ids[entry.OrderID] = &entry
+	}
+	sort.SliceStable(b.entries, func(i, j int) bool {
+		return b.entries[i].Price.Cmp(b.entries[j].Price) > 0
+	})
+	return b
+}
+
+func (b *sliceBook) Insert(price, size Decimal, orderID string) {
+	i := len(b.entries)
+	for j, e := range b.entries {
+		if e.Price.Cmp(price) < 0 {
+			i = j
+			break
+		}
+	}
+
+	entry := OrderBookEntry{Price: price, Size: size, OrderID: orderID}
+	b.entries = append(b.entries[:i], append([]*OrderBookEntry{&entry}, b.entries[i:]...)...)
+	b.ids[orderID] = &entry
+}
+
+func (b *sliceBook) Delete(orderID string) {
+	delete(b.ids, orderID)
+	for i, e := range b.entries {
+		if e.OrderID == orderID {
+			b.entries = append(b.entries[:i], b.entries[i+1:]...)
+			break
+		}
+	}
+}
+
+func (b *sliceBook) Change(orderID string, size Decimal) {
+	for _, e := range b.entries {
+		if e.OrderID == orderID {
+			e.Size = size
+			break
+		}
+	}
+}
+
+// bookOrders makes n orders spread over 2000 price levels, roughly the shape
+// of a level 3 BTC-USD book
+func bookOrders(n int) []OrderBookEntry {
+	r := rand.New(rand.NewSource(1))
+	orders := make([]OrderBookEntry, n)
+	for i := range orders {
+		orders[i] = OrderBookEntry{
+			Price:   d(fmt.Sprintf("%d.%02d", 9000+r.Intn(20), r.Intn(100))),
+			Size:    d("0.5"),
+			OrderID: strconv.Itoa(i),
+		}
+	}
+	return orders
+}
+
+func benchmarkDepths(b *testing.B, fn func(b *testing.B, orders []OrderBookEntry)) {
+	for _, n := range []int{1000, 10000, 50000} {
+		orders := bookOrders(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) { fn(b, orders) })
+	}
+}
+
+// BenchmarkBookInsertDelete and BenchmarkSliceBookInsertDelete add and remove
+// an order in a book of each depth, the work done for an "open" message and
+// its "done"
+func BenchmarkBookInsertDelete(b *testing.B) {
+	benchmarkDepths(b, func(b *testing.B, orders []OrderBookEntry) {
+		ob := NewOrderBook()
+		for _, o := range orders {
+			ob.Insert(BidSide, o.Price, o.Size, o.OrderID)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			o := orders[i%len(orders)]
+			ob.Insert(BidSide, o.Price, o.Size, "new")
+			ob.Delete("new")
+		}
+	})
+}
+
+func BenchmarkSliceBookInsertDelete(b *testing.B) {
+	benchmarkDepths(b, func(b *testing.B, orders []OrderBookEntry) {
+		sb := newSliceBook(orders)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			o := orders[i%len(orders)]
+			sb.Insert(o.Price, o.Size, "new")
+			sb.Delete("new")
+		}
+	})
+}
+
+// BenchmarkBookChange and BenchmarkSliceBookChange update the size of an order
+// in a book of each depth, the work done for a "match" or "change" message
+func BenchmarkBookChange(b *testing.B) {
+	benchmarkDepths(b, func(b *testing.B, orders []OrderBookEntry) {
+		ob := NewOrderBook()
+		for _, o := range orders {
+			ob.Insert(BidSide, o.Price, o.Size, o.OrderID)
+		}
+		size := d("0.25")
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ob.Change(orders[i%len(orders)].OrderID, size)
+		}
+	})
+}
+
+func BenchmarkSliceBookChange(b *testing.B) {
+	benchmarkDepths(b, func(b *testing.B, orders []OrderBookEntry) {
+		sb := newSliceBook(orders)
+		size := d("0.25")
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			sb.Change(orders[i%len(orders)].OrderID, size)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package gdax
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact, arbitrary-precision number. GDAX transmits prices and
+// sizes as decimal strings; keeping them as Decimals instead of float64s means
+// sums and products of amounts never drift from the values on the exchange.
+// The zero value is 0. Decimals are immutable, every operation returns a new
+// value.
+type Decimal struct {
+	rat *big.Rat
+}
+
+// NewDecimal parses a decimal string such as "123.45000000"
+func NewDecimal(s string) (Decimal, error) {
+	if strings.ContainsRune(s, '/') {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{r}, nil
+}
+
+// DecimalFromInt returns the Decimal representation of an integer
+func DecimalFromInt(i int64) Decimal {
+	return Decimal{new(big.Rat).SetInt64(i)}
+}
+
+func (d Decimal) r() *big.Rat {
+	if d.rat == nil {
+		return new(big.Rat)
+	}
+	return d.rat
+}
+
+func (d Decimal) Add(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Add(d.r(), e.r())}
+}
+
+func (d Decimal) Sub(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Sub(d.r(), e.r())}
+}
+
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Mul(d.r(), e.r())}
+}
+
+// Div divides d by e. Dividing by zero returns zero, callers are expected to
+// check the divisor when it matters
+func (d Decimal) Div(e Decimal) Decimal {
+	if e.Sign() == 0 {
+		return Decimal{}
+	}
+	return Decimal{new(big.Rat).Quo(d.r(), e.r())}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{new(big.Rat).Neg(d.r())}
+}
+
+// Cmp returns -1, 0 or +1 depending on whether d is less than, equal to or
+// greater than e
+func (d Decimal) Cmp(e Decimal) int {
+	return d.r().Cmp(e.r())
+}
+
+func (d Decimal) Sign() int {
+	return d.r().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Round returns d rounded to the given number of decimal places, with halves
+// rounded away from zero
+func (d Decimal) Round(places int) Decimal {
+	if places < 0 {
+		return d
+	}
+	r, _ := new(big.Rat).SetString(d.r().FloatString(places))
+	return Decimal{r}
+}
+
+// StringFixed formats d with exactly the given number of decimal places
+func (d Decimal) StringFixed(places int) string {
+	if places < 0 {
+		return d.String()
+	}
+	return d.r().FloatString(places)
+}
+
+// String formats d using as many decimal places as are needed to represent it
+// exactly. Values with no finite decimal representation are rounded to 18
+// places.
+func (d Decimal) String() string {
+	s := d.r().FloatString(d.places())
+	if strings.ContainsRune(s, '.') {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// places returns the number of decimal places needed to represent d, which is
+// the larger of the powers of 2 and 5 in its denominator
+func (d Decimal) places() int {
+	denom := new(big.Int).Set(d.r().Denom())
+	two, five := big.NewInt(2), big.NewInt(5)
+	mod := new(big.Int)
+
+	var twos, fives int
+	for {
+		q, m := new(big.Int).QuoRem(denom, two, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		denom, twos = q, twos+1
+	}
+	for {
+		q, m := new(big.Int).QuoRem(denom, five, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		denom, fives = q, fives+1
+	}
+
+	if denom.Cmp(big.NewInt(1)) != 0 {
+		return 18
+	}
+	if twos > fives {
+		return twos
+	}
+	return fives
+}
+
+// Float64 returns the nearest float64 to d, for use where exactness doesn't
+// matter such as ratios and metrics
+func (d Decimal) Float64() float64 {
+	f, _ := d.r().Float64()
+	return f
+}
+
+// MarshalJSON implements the json.Marshaler interface. Decimals are encoded as
+// strings, the same way GDAX encodes them
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface and accepts either a
+// string or a bare number. null leaves d as it is, like it does for the
+// built-in types
+func (d *Decimal) UnmarshalJSON(buf []byte) error {
+	if string(buf) == "null" {
+		return nil
+	}
+
+	s := string(buf)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(buf, &s); err != nil {
+			return err
+		}
+	}
+	parsed, err := NewDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
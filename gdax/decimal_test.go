@@ -0,0 +1,75 @@
+package gdax
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecimalArithmetic(t *testing.T) {
+	// 0.1 + 0.2 is the canonical float64 failure
+	if sum := d("0.1").Add(d("0.2")); sum.Cmp(d("0.3")) != 0 {
+		t.Errorf("0.1 + 0.2 should be 0.3, got %s", sum)
+	}
+
+	if product := d("0.00000001").Mul(d("12345.67")); product.String() != "0.0001234567" {
+		t.Errorf("incorrect product, got %s", product)
+	}
+
+	if quotient := d("1").Div(d("3")); quotient.StringFixed(8) != "0.33333333" {
+		t.Errorf("incorrect quotient, got %s", quotient.StringFixed(8))
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		places   int
+		expected string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004999999", 2, "1"},
+		{"0.123456785", 8, "0.12345679"},
+		{"-2.5", 0, "-3"},
+	} {
+		if r := d(c.in).Round(c.places); r.String() != c.expected {
+			t.Errorf("%s rounded to %d places should be %s, got %s",
+				c.in, c.places, c.expected, r)
+		}
+	}
+}
+
+func TestDecimalParse(t *testing.T) {
+	for _, s := range []string{"", "abc", "1/3", "1.2.3"} {
+		if _, err := NewDecimal(s); err == nil {
+			t.Errorf("%q should not parse as a decimal", s)
+		}
+	}
+}
+
+func TestDecimalUnmarshalJSON(t *testing.T) {
+	for raw, expected := range map[string]string{
+		`"123.45000000"`: "123.45",
+		`123.45`:         "123.45",
+		`null`:           "7",
+	} {
+		v := d("7")
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			t.Errorf("%s: %s", raw, err)
+		} else if v.String() != expected {
+			t.Errorf("%s should unmarshal as %s, got %s", raw, expected, v)
+		}
+	}
+
+	// a nullable field is left nil
+	var fields struct{ Price *Decimal }
+	if err := json.Unmarshal([]byte(`{"Price":null}`), &fields); err != nil || fields.Price != nil {
+		t.Errorf("null should leave the field nil, got %v, %v", fields.Price, err)
+	}
+
+	for _, raw := range []string{`"1"2"`, `"abc"`, `"12`, `true`} {
+		var v Decimal
+		if err := json.Unmarshal([]byte(raw), &v); err == nil {
+			t.Errorf("%s should not unmarshal as a decimal", raw)
+		}
+	}
+}
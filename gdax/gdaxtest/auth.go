@@ -0,0 +1,188 @@
+package gdaxtest
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akb/quoted/gdax"
+)
+
+// how far a signed request's timestamp may be from the server's clock, the
+// same as GDAX allows
+const timestampWindow = 30 * time.Second
+
+// writeMessage writes an error response the way GDAX does
+func writeMessage(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	body, _ := json.Marshal(struct {
+		Message string `json:"message"`
+	}{fmt.Sprintf(format, a...)})
+	w.Write(body)
+}
+
+// authenticated wraps a handler for an endpoint that requires requests to be
+// signed with the server's Credentials
+func (s *Server) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+
+		err = s.verify(
+			r.Header.Get("CB-ACCESS-KEY"),
+			r.Header.Get("CB-ACCESS-PASSPHRASE"),
+			r.Header.Get("CB-ACCESS-TIMESTAMP"),
+			r.Header.Get("CB-ACCESS-SIGN"),
+			r.Method, r.URL.RequestURI(), string(body))
+		if err != nil {
+			writeMessage(w, http.StatusUnauthorized, "%s", err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// verify returns an error describing what's wrong with the signature of a
+// request, if anything. Feed subscriptions are signed as a GET of
+// /users/self/verify
+func (s *Server) verify(key, passphrase, timestamp, signature, method, path, body string) error {
+	credentials := s.Credentials
+	if credentials == nil || key != credentials.Key {
+		return fmt.Errorf("Invalid API Key")
+	}
+	if passphrase != credentials.Passphrase {
+		return fmt.Errorf("Invalid Passphrase")
+	}
+
+	seconds, err := strconv.ParseFloat(timestamp, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	at := time.Unix(0, int64(seconds*float64(time.Second)))
+	if d := time.Since(at); d > timestampWindow || d < -timestampWindow {
+		return fmt.Errorf("request timestamp expired")
+	}
+
+	expected, err := credentials.Sign(timestamp, method, path, body)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// GET /accounts and /accounts/{id}
+func (s *Server) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMessage(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/accounts"), "/")
+	if len(id) == 0 {
+		writeJSON(w, s.Accounts)
+		return
+	}
+	for _, a := range s.Accounts {
+		if a.ID == id {
+			writeJSON(w, a)
+			return
+		}
+	}
+	writeMessage(w, http.StatusNotFound, "NotFound")
+}
+
+// GET /orders and /orders/{id}. Only open orders are listed.
+func (s *Server) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMessage(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/orders"), "/")
+	if len(id) == 0 {
+		productID := r.URL.Query().Get("product_id")
+		orders := []gdax.Order{}
+		for _, o := range s.Orders {
+			if o.Status == "open" && (len(productID) == 0 || o.ProductID == productID) {
+				orders = append(orders, o)
+			}
+		}
+		start, end := s.page(w, r, len(orders))
+		writeJSON(w, orders[start:end])
+		return
+	}
+	for _, o := range s.Orders {
+		if o.ID == id {
+			writeJSON(w, o)
+			return
+		}
+	}
+	writeMessage(w, http.StatusNotFound, "NotFound")
+}
+
+// GET /fills
+func (s *Server) handleFills(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMessage(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	query := r.URL.Query()
+	orderID, productID := query.Get("order_id"), query.Get("product_id")
+	fills := []gdax.OrderFill{}
+	for _, f := range s.Fills {
+		if (len(orderID) == 0 || f.OrderID == orderID) &&
+			(len(productID) == 0 || f.ProductID == productID) {
+			fills = append(fills, f)
+		}
+	}
+	start, end := s.page(w, r, len(fills))
+	writeJSON(w, fills[start:end])
+}
+
+// page returns the range of n items a request for a paginated list gets,
+// PageSize of them after the cursor it gives, and sets the cursor of the next
+// page. Like GDAX, the cursor is set on every page that isn't empty, and the
+// page after the last is empty. The cursor is the index the page starts at.
+func (s *Server) page(w http.ResponseWriter, r *http.Request, n int) (int, int) {
+	if s.PageSize <= 0 {
+		return 0, n
+	}
+
+	start, err := strconv.Atoi(r.URL.Query().Get("after"))
+	if err != nil || start < 0 {
+		start = 0
+	} else if start > n {
+		start = n
+	}
+	end := start + s.PageSize
+	if end > n {
+		end = n
+	}
+	if end > start {
+		w.Header().Set("CB-AFTER", strconv.Itoa(end))
+	}
+	return start, end
+}
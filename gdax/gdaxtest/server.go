@@ -0,0 +1,524 @@
+// Package gdaxtest provides a stand-in for the GDAX REST API and websocket
+// feed, for testing code that talks to GDAX without using the network.
+package gdaxtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/akb/quoted/gdax"
+)
+
+// how long the feed waits for a product's snapshot to be requested before
+// sending its messages anyway
+const snapshotWait = 5 * time.Second
+
+// Order is a single order in a level 3 order book snapshot: its price, size
+// and order ID, in the 3-tuple GDAX uses
+type Order [3]string
+
+// Book is a level 3 order book snapshot, served from
+// /products/{id}/book?level=3. It is also served aggregated by price from
+// /products/{id}/book?level=2
+type Book struct {
+	Sequence int64   `json:"sequence"`
+	Bids     []Order `json:"bids"`
+	Asks     []Order `json:"asks"`
+}
+
+// Message is a feed message as GDAX encodes it, with prices and sizes as
+// strings. Scripts are written in them, so they can hold anything GDAX might
+// send, malformed messages included. Fields a type doesn't use are left
+// empty.
+type Message struct {
+	Type           string `json:"type"`
+	Time           string `json:"time,omitempty"`
+	Sequence       int64  `json:"sequence,omitempty"`
+	ProductID      string `json:"product_id,omitempty"`
+	OrderID        string `json:"order_id,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Price          string `json:"price,omitempty"`
+	Side           string `json:"side,omitempty"`
+	OrderType      string `json:"order_type,omitempty"`
+	Funds          string `json:"funds,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	RemainingSize  string `json:"remaining_size,omitempty"`
+	TradeID        int64  `json:"trade_id,omitempty"`
+	MakerOrderID   string `json:"maker_order_id,omitempty"`
+	TakerOrderID   string `json:"taker_order_id,omitempty"`
+	UserID         string `json:"user_id,omitempty"`
+	ProfileID      string `json:"profile_id,omitempty"`
+	TakerUserID    string `json:"taker_user_id,omitempty"`
+	TakerProfileID string `json:"taker_profile_id,omitempty"`
+	NewSize        string `json:"new_size,omitempty"`
+	OldSize        string `json:"old_size,omitempty"`
+	NewFunds       string `json:"new_funds,omitempty"`
+	OldFunds       string `json:"old_funds,omitempty"`
+	LastTradeID    int64  `json:"last_trade_id,omitempty"`
+	Message        string `json:"message,omitempty"`
+
+	// Channels lists what's subscribed to in a subscriptions message
+	Channels []gdax.Channel `json:"channels,omitempty"`
+
+	// Bids and Asks hold [price, size] pairs in a level2 snapshot, and Changes
+	// holds [side, price, size] updates in an l2update
+	Bids    [][]string `json:"bids,omitempty"`
+	Asks    [][]string `json:"asks,omitempty"`
+	Changes [][]string `json:"changes,omitempty"`
+}
+
+// Server is a fake GDAX. It serves products, currencies and order book
+// snapshots over HTTP and, to each websocket client that subscribes, replays a
+// script of feed messages for the subscribed products.
+//
+// Products and Currencies are listed from /products and /currencies. NewServer
+// fills them in for the products it has books for, and they can be changed
+// before the server is used.
+//
+// Accounts, Orders and Fills are served from the authenticated /accounts,
+// /orders and /fills endpoints, which only answer requests signed with
+// Credentials, rejecting them all when it's nil. They can be set before the
+// server is used. Fills are listed in the order they're given, which should be
+// newest first like GDAX.
+// When PageSize is set, /orders and /fills list that many at a time, with a
+// CB-AFTER cursor for the next page like GDAX.
+//
+// Snapshot and l2update messages in the script are only sent to clients
+// subscribed to the level2 channel, and all others only to those subscribed
+// to the full channel, which is what a subscription without channels gets.
+// Scripted messages about our own orders, with a UserID or ProfileID, only
+// keep them for clients whose subscriptions are signed with Credentials.
+//
+// A product's messages are held back until its snapshot has been requested,
+// so that a client which subscribes before loading the snapshot (the way
+// gdax.LiveOrderBook does) sees every scripted message.
+//
+// Clients can subscribe to more products, and unsubscribe from them, with
+// further subscribe and unsubscribe messages. A product's script is sent
+// whenever it's newly subscribed to. Each subscribe and unsubscribe message
+// is answered with a subscriptions message listing everything subscribed to,
+// except for subscriptions to products that aren't listed in Products, which
+// are rejected with an error message, like GDAX does.
+type Server struct {
+	*httptest.Server
+
+	Products   []gdax.Product
+	Currencies []gdax.Currency
+
+	Credentials *gdax.Credentials
+	Accounts    []gdax.Account
+	Orders      []gdax.Order
+	Fills       []gdax.OrderFill
+	PageSize    int
+
+	books  map[string]Book
+	script []Message
+
+	lock      *sync.Mutex
+	requested map[string]chan struct{}
+}
+
+// NewServer starts a fake GDAX serving the given snapshots, keyed by product
+// ID, and replaying script on the websocket feed. The caller should call
+// Close when finished.
+func NewServer(books map[string]Book, script []Message) *Server {
+	s := &Server{
+		books:     books,
+		script:    script,
+		lock:      &sync.Mutex{},
+		requested: map[string]chan struct{}{},
+	}
+	for productID := range books {
+		s.requested[productID] = make(chan struct{})
+	}
+	productIDs := make([]string, 0, len(books))
+	for productID := range books {
+		productIDs = append(productIDs, productID)
+	}
+	s.Products, s.Currencies = listProducts(productIDs)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/products", s.handleProducts)
+	mux.HandleFunc("/currencies", s.handleCurrencies)
+	mux.HandleFunc("/products/", s.handleBook)
+	mux.HandleFunc("/accounts", s.authenticated(s.handleAccounts))
+	mux.HandleFunc("/accounts/", s.authenticated(s.handleAccounts))
+	mux.HandleFunc("/orders", s.authenticated(s.handleOrders))
+	mux.HandleFunc("/orders/", s.authenticated(s.handleOrders))
+	mux.HandleFunc("/fills", s.authenticated(s.handleFills))
+	mux.Handle("/feed", websocket.Handler(s.handleFeed))
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// WebsocketURL returns the URL of the fake websocket feed
+func (s *Server) WebsocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/feed"
+}
+
+// NewRegistry returns a registry of the products, with the same made up
+// metadata a Server lists for them
+func NewRegistry(productIDs ...string) *gdax.Registry {
+	return gdax.NewRegistry(listProducts(productIDs))
+}
+
+// listProducts makes up product and currency metadata for products, with
+// GDAX's precisions: 2 places for fiat currencies and 8 for the rest
+func listProducts(productIDs []string) ([]gdax.Product, []gdax.Currency) {
+	increment := func(currency string) gdax.Decimal {
+		s := "0.00000001"
+		if fiat[currency] {
+			s = "0.01"
+		}
+		d, _ := gdax.NewDecimal(s)
+		return d
+	}
+	minSize, _ := gdax.NewDecimal("0.01")
+	maxSize, _ := gdax.NewDecimal("10000")
+
+	productIDs = append([]string{}, productIDs...)
+	sort.Strings(productIDs)
+
+	var products []gdax.Product
+	var currencies []gdax.Currency
+	seen := map[string]bool{}
+	for _, productID := range productIDs {
+		parts := strings.SplitN(productID, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		products = append(products, gdax.Product{
+			ID:             productID,
+			BaseCurrency:   parts[0],
+			QuoteCurrency:  parts[1],
+			BaseMinSize:    minSize,
+			BaseMaxSize:    maxSize,
+			QuoteIncrement: increment(parts[1]),
+			Status:         gdax.ProductOnline,
+		})
+		for _, currency := range parts {
+			if !seen[currency] {
+				seen[currency] = true
+				currencies = append(currencies, gdax.Currency{
+					ID: currency, Name: currency, MinSize: increment(currency),
+				})
+			}
+		}
+	}
+
+	return products, currencies
+}
+
+var fiat = map[string]bool{"USD": true, "EUR": true, "GBP": true}
+
+// GET /products
+func (s *Server) handleProducts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Products)
+}
+
+// GET /currencies
+func (s *Server) handleCurrencies(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Currencies)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// GET /products/{id}/book
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[2] != "book" {
+		http.NotFound(w, r)
+		return
+	}
+
+	book, ok := s.books[parts[1]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"NotFound"}`))
+		return
+	}
+
+	var body []byte
+	var err error
+	switch r.URL.Query().Get("level") {
+	case "2":
+		body, err = json.Marshal(aggregate(book))
+	case "3":
+		body, err = json.Marshal(book)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"only level 2 and 3 books are supported"}`))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.lock.Lock()
+	requested := s.requested[parts[1]]
+	select {
+	case <-requested:
+	default:
+		close(requested)
+	}
+	s.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// aggregate totals the orders at each price of a book, giving the
+// [price, size, num_orders] entries of a level 2 snapshot
+func aggregate(book Book) interface{} {
+	side := func(orders []Order) [][3]interface{} {
+		var levels [][3]interface{}
+		var total gdax.Decimal
+		for i, o := range orders {
+			size, _ := gdax.NewDecimal(o[1])
+			total = total.Add(size)
+			if i+1 < len(orders) && orders[i+1][0] == o[0] {
+				continue
+			}
+
+			n := 1
+			for j := i - 1; j >= 0 && orders[j][0] == o[0]; j-- {
+				n++
+			}
+			levels = append(levels, [3]interface{}{o[0], total.String(), n})
+			total = gdax.Decimal{}
+		}
+		return levels
+	}
+
+	return struct {
+		Sequence int64            `json:"sequence"`
+		Bids     [][3]interface{} `json:"bids"`
+		Asks     [][3]interface{} `json:"asks"`
+	}{book.Sequence, side(book.Bids), side(book.Asks)}
+}
+
+// channelFor returns the channel a scripted message is sent on
+func channelFor(m Message) string {
+	if m.Type == gdax.SnapshotMessage || m.Type == gdax.L2UpdateMessage {
+		return gdax.Level2Channel
+	}
+	return gdax.FullChannel
+}
+
+// subscriptions parses the channels of a subscribe message, which may be
+// names or objects with their own product IDs, into the set of products
+// subscribed to on each channel
+func subscriptions(productIDs []string, channels []json.RawMessage,
+) (map[string]map[string]bool, error) {
+	subscribed := map[string]map[string]bool{}
+	add := func(channel string, productIDs []string) {
+		if subscribed[channel] == nil {
+			subscribed[channel] = map[string]bool{}
+		}
+		for _, productID := range productIDs {
+			subscribed[channel][productID] = true
+		}
+	}
+
+	if len(channels) == 0 {
+		add(gdax.FullChannel, productIDs)
+	}
+	for _, raw := range channels {
+		var name string
+		if err := json.Unmarshal(raw, &name); err == nil {
+			add(name, productIDs)
+			continue
+		}
+
+		var channel gdax.Channel
+		if err := json.Unmarshal(raw, &channel); err != nil {
+			return nil, err
+		}
+		if len(channel.ProductIDs) == 0 {
+			channel.ProductIDs = productIDs
+		}
+		add(channel.Name, channel.ProductIDs)
+	}
+	return subscribed, nil
+}
+
+// subscribeMessage is a subscribe or unsubscribe message from a client
+type subscribeMessage struct {
+	Type       string            `json:"type"`
+	ProductIDs []string          `json:"product_ids"`
+	Channels   []json.RawMessage `json:"channels"`
+	Signature  string            `json:"signature"`
+	Key        string            `json:"key"`
+	Passphrase string            `json:"passphrase"`
+	Timestamp  string            `json:"timestamp"`
+}
+
+func (s *Server) handleFeed(ws *websocket.Conn) {
+	subscribed := map[string]map[string]bool{}
+	authenticated := false
+	for first := true; ; first = false {
+		var request subscribeMessage
+		if err := websocket.JSON.Receive(ws, &request); err != nil {
+			return
+		}
+		if request.Type != "subscribe" && (first || request.Type != "unsubscribe") {
+			websocket.JSON.Send(ws, Message{
+				Type:    gdax.ErrorMessage,
+				Message: fmt.Sprintf("unexpected %s message", request.Type),
+			})
+			return
+		}
+
+		requested, err := subscriptions(request.ProductIDs, request.Channels)
+		if err != nil {
+			websocket.JSON.Send(ws, Message{
+				Type:    gdax.ErrorMessage,
+				Message: fmt.Sprintf("invalid channels: %s", err),
+			})
+			return
+		}
+
+		if request.Type == "unsubscribe" {
+			for channel, productIDs := range requested {
+				for productID := range productIDs {
+					delete(subscribed[channel], productID)
+				}
+			}
+			if err := websocket.JSON.Send(ws, subscriptionsMessage(subscribed)); err != nil {
+				return
+			}
+			continue
+		}
+
+		if len(request.Signature) > 0 {
+			err := s.verify(request.Key, request.Passphrase, request.Timestamp,
+				request.Signature, http.MethodGet, "/users/self/verify", "")
+			if err != nil {
+				websocket.JSON.Send(ws, Message{Type: gdax.ErrorMessage, Message: err.Error()})
+				return
+			}
+			authenticated = true
+		}
+
+		if productID, ok := s.unlisted(requested); ok {
+			err := websocket.JSON.Send(ws, Message{
+				Type:    gdax.ErrorMessage,
+				Message: "Failed to subscribe",
+				Reason:  fmt.Sprintf("%s is not a valid product", productID),
+			})
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		// only what wasn't already subscribed to is scripted
+		added := map[string]map[string]bool{}
+		for channel, productIDs := range requested {
+			if subscribed[channel] == nil {
+				subscribed[channel] = map[string]bool{}
+			}
+			added[channel] = map[string]bool{}
+			for productID := range productIDs {
+				if !subscribed[channel][productID] {
+					subscribed[channel][productID] = true
+					added[channel][productID] = true
+				}
+			}
+		}
+		if err := websocket.JSON.Send(ws, subscriptionsMessage(subscribed)); err != nil {
+			return
+		}
+		if !s.sendScript(ws, added, authenticated) {
+			return
+		}
+	}
+}
+
+// unlisted returns a product subscribed to that isn't in Products, if any
+func (s *Server) unlisted(subscribed map[string]map[string]bool) (string, bool) {
+	listed := map[string]bool{}
+	for _, p := range s.Products {
+		listed[p.ID] = true
+	}
+
+	var unlisted []string
+	for _, productIDs := range subscribed {
+		for productID := range productIDs {
+			if !listed[productID] {
+				unlisted = append(unlisted, productID)
+			}
+		}
+	}
+	if len(unlisted) == 0 {
+		return "", false
+	}
+	sort.Strings(unlisted)
+	return unlisted[0], true
+}
+
+// subscriptionsMessage lists what's subscribed to, by channel
+func subscriptionsMessage(subscribed map[string]map[string]bool) Message {
+	m := Message{Type: gdax.SubscriptionsMessage, Channels: []gdax.Channel{}}
+	for name, productIDs := range subscribed {
+		if len(productIDs) == 0 {
+			continue
+		}
+		channel := gdax.Channel{Name: name}
+		for productID := range productIDs {
+			channel.ProductIDs = append(channel.ProductIDs, productID)
+		}
+		sort.Strings(channel.ProductIDs)
+		m.Channels = append(m.Channels, channel)
+	}
+	sort.Slice(m.Channels, func(i, j int) bool {
+		return m.Channels[i].Name < m.Channels[j].Name
+	})
+	return m
+}
+
+// sendScript sends a client the scripted messages for the products it has
+// just subscribed to, and reports whether they were all sent
+func (s *Server) sendScript(ws *websocket.Conn, added map[string]map[string]bool, authenticated bool) bool {
+	for _, m := range s.script {
+		if !added[channelFor(m)][m.ProductID] {
+			continue
+		}
+		if !authenticated {
+			m.UserID, m.ProfileID, m.TakerUserID, m.TakerProfileID = "", "", "", ""
+		}
+
+		s.lock.Lock()
+		requested, ok := s.requested[m.ProductID]
+		s.lock.Unlock()
+		if ok {
+			select {
+			case <-requested:
+			case <-time.After(snapshotWait):
+			}
+		}
+
+		if err := websocket.JSON.Send(ws, m); err != nil {
+			return false
+		}
+	}
+	return true
+}
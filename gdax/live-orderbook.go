@@ -2,13 +2,27 @@ package gdax
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
 	"sync"
+	"time"
 )
 
+// ErrBookUnavailable is returned when quoting from a book that is still
+// loading or is resynchronizing after missing messages from the feed
+var ErrBookUnavailable = errors.New("order book unavailable")
+
+// how long to wait before trying again when the order book can't be fetched
+const resetRetryDelay = 5 * time.Second
+
+// how many feed messages a book that isn't running holds on to. past this the
+// snapshot is taking too long for them to be caught up from, so they're
+// discarded and the book is reset again. a variable so tests can shrink it
+var maxQueuedMessages = 100000
+
 type liveOrderBookState string
 
 const (
@@ -16,6 +30,7 @@ const (
 	loadingState       liveOrderBookState = "loading"
 	synchronizingState liveOrderBookState = "synchronizing"
 	runningState       liveOrderBookState = "running"
+	closedState        liveOrderBookState = "closed"
 )
 
 type liveOrderBookAction string
@@ -26,73 +41,251 @@ const (
 	runAction         liveOrderBookAction = "run"
 )
 
+// MessageSource is a stream of feed messages that order books subscribe to.
+// Feed and Replay are both message sources
+type MessageSource interface {
+	Subscribe(c chan Message, filter Filter) *Subscription
+}
+
 type LiveOrderBook struct {
 	*sync.RWMutex
 	*OrderBook
 
-	api     API
-	client  *http.Client
-	context context.Context
+	// fetches a fresh snapshot of the order book
+	loadSnapshot func() (*OrderBook, error)
+
+	// the book's place in its source's fan-out
+	subscription *Subscription
 
 	productID       string
+	level           int
 	state           liveOrderBookState
 	droppedMessages int64
+	gapTolerance    int64
+	lastMessageTime time.Time
 
-	// second mutex is used to avoid deadlocks
+	// set from when the feed disconnects until it reconnects, while the book
+	// can't be kept up to date
+	feedDown bool
+
+	// closed and cleared the next time the book changes, created only when
+	// someone is waiting for a change
+	changed chan struct{}
+
+	// lifetime totals, these aren't cleared by a reset
+	totalDroppedMessages int64
+	resets               int64
+
+	// second mutex is used to avoid deadlocks. when both are needed queueLock
+	// must be acquired first
 	queueLock *sync.RWMutex
 	queue     []Message
 
+	// closed by Close to stop the main loop
+	closed    chan struct{}
+	closeOnce *sync.Once
+
 	actionChan chan liveOrderBookAction
-	ErrorChan  chan error
+
+	// closed once the book has stopped, by Close or because its feed or the
+	// done channel it was made with were closed
+	ErrorChan chan error
 }
 
+// NewLiveOrderBook creates an order book for productID that is kept up to date
+// from feed. A level 3 book tracks every order and needs the feed's full
+// channel. A level 2 book keeps only the total size at each price, from the
+// much lighter level2 channel, which is enough to quote from.
+//
+// When the API has credentials, a level 3 book leaves our own open orders out
+// of quotes: those open when a snapshot is loaded, and those the feed reports
+// opening afterwards, which it only does for a feed made with the same
+// credentials. Level 2 books can't tell our orders apart from the rest.
 func (a API) NewLiveOrderBook(
 	c *http.Client, ctx context.Context, feed *Feed,
-	productID string, done <-chan struct{},
+	productID string, level int, done <-chan struct{},
 ) (*LiveOrderBook, error) {
-	if !IsValidProductID(productID) {
-		return nil, fmt.Errorf("%s is not a valid product id", productID)
+	if level != 2 && level != 3 {
+		return nil, fmt.Errorf("Live order books must be level 2 or 3, got %d", level)
+	}
+
+	loadSnapshot := func() (*OrderBook, error) {
+		body, err := a.GetOrderBookJSON(c, ctx, productID, level)
+		if err != nil {
+			return nil, err
+		}
+
+		if recorder := feed.getRecorder(); recorder != nil {
+			if err := recorder.RecordSnapshot(productID, body); err != nil {
+				fmt.Fprintf(os.Stderr, "Error recording snapshot: %s\n", err)
+			}
+		}
+
+		ob := OrderBook{}
+		if err := json.Unmarshal(body, &ob); err != nil {
+			return nil, err
+		}
+
+		// fetched after the snapshot, so orders that open in between are
+		// reported by the feed, and orders that close are deleted by it
+		if a.Credentials != nil && level == 3 {
+			orders, err := a.GetOrders(c, ctx, productID)
+			if err != nil {
+				return nil, fmt.Errorf("Error loading our open orders: %s", err)
+			}
+			for _, o := range orders {
+				ob.SetOwn(o.ID)
+			}
+		}
+		return &ob, nil
 	}
 
+	return newLiveOrderBook(feed, loadSnapshot, productID, level, done), nil
+}
+
+func newLiveOrderBook(
+	source MessageSource, loadSnapshot func() (*OrderBook, error),
+	productID string, level int, done <-chan struct{},
+) *LiveOrderBook {
 	lob := LiveOrderBook{
 		RWMutex:   &sync.RWMutex{},
 		OrderBook: nil,
 
-		api:     a,
-		client:  c,
-		context: ctx,
+		loadSnapshot: loadSnapshot,
 
 		productID:       productID,
+		level:           level,
 		state:           newState,
 		droppedMessages: 0,
+		gapTolerance:    0,
 
 		queueLock: &sync.RWMutex{},
 		queue:     []Message{},
 
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+
 		actionChan: make(chan liveOrderBookAction, 1),
 		ErrorChan:  make(chan error),
 	}
 
-	go lob.listen(feed)
-	go lob.loop(done)
+	// subscribe before returning so no messages are missed between now and
+	// the first snapshot
+	messageChan := make(chan Message)
+	lob.subscription = source.Subscribe(messageChan, Filter{ProductIDs: []string{productID}})
+
+	// errors are only sent from these two, so once they've both stopped there
+	// won't be any more
+	running := &sync.WaitGroup{}
+	running.Add(2)
+	go func() {
+		defer running.Done()
+		lob.listen(messageChan)
+	}()
+	go func() {
+		defer running.Done()
+		lob.loop(done)
+	}()
+	go func() {
+		running.Wait()
+		close(lob.ErrorChan)
+	}()
 
 	defer lob.Reset()
 
-	return &lob, nil
+	return &lob
 }
 
-// Reset clears the order book, fetches a new state and re-synchronizes it
+// Reset clears the order book, fetches a new state and re-synchronizes it. It
+// doesn't block, and resets requested while one is already pending are
+// coalesced into it.
 func (lob *LiveOrderBook) Reset() {
-	lob.actionChan <- resetAction
+	select {
+	case lob.actionChan <- resetAction:
+	default:
+	}
 }
 
-// Quote is a thread-safe method proxy for OrderBook::Quote
-func (lob *LiveOrderBook) Quote(
-	action, currency string, amount float64, inverse bool,
-) (price, total float64, err error) {
+// Close stops keeping the book up to date and unsubscribes it from its feed.
+// It can't be quoted from afterwards.
+func (lob *LiveOrderBook) Close() {
+	lob.closeOnce.Do(func() {
+		close(lob.closed)
+		if lob.subscription != nil {
+			lob.subscription.Unsubscribe()
+		}
+		lob.setState(closedState)
+	})
+}
+
+// SetGapTolerance sets how many messages may be missed from the feed since
+// the last reset before the book is considered wrong and resynchronized. The
+// default of 0 resynchronizes on any gap.
+func (lob *LiveOrderBook) SetGapTolerance(n int64) {
+	lob.Lock()
+	defer lob.Unlock()
+	lob.gapTolerance = n
+}
+
+// Quote is a thread-safe method proxy for OrderBook::Quote. It returns
+// ErrBookUnavailable unless the book is synchronized with the feed.
+func (lob *LiveOrderBook) Quote(action string, amount Decimal, funds bool) (Quote, error) {
 	lob.RLock()
 	defer lob.RUnlock()
-	return lob.OrderBook.Quote(action, currency, amount, inverse)
+	if lob.state != runningState {
+		return Quote{}, ErrBookUnavailable
+	}
+
+	q, err := lob.OrderBook.Quote(action, amount, funds)
+	q.Time = time.Now()
+	return q, err
+}
+
+// LiveOrderBookStatus is a snapshot of a LiveOrderBook's synchronization
+// with the feed
+type LiveOrderBookStatus struct {
+	ProductID       string
+	Level           int
+	State           string
+	Running         bool
+	Sequence        int64
+	LastMessageTime time.Time
+	DroppedMessages int64
+	BidDepth        int
+	AskDepth        int
+
+	// how far the book is behind its feed
+	Feed SubscriptionStats
+
+	TotalDroppedMessages int64
+	Resets               int64
+}
+
+// Status returns the current state of the book
+func (lob *LiveOrderBook) Status() LiveOrderBookStatus {
+	lob.RLock()
+	defer lob.RUnlock()
+
+	status := LiveOrderBookStatus{
+		ProductID:       lob.productID,
+		Level:           lob.level,
+		State:           string(lob.state),
+		Running:         lob.state == runningState,
+		LastMessageTime: lob.lastMessageTime,
+		DroppedMessages: lob.droppedMessages,
+
+		TotalDroppedMessages: lob.totalDroppedMessages,
+		Resets:               lob.resets,
+	}
+	if lob.subscription != nil {
+		status.Feed = lob.subscription.Stats()
+	}
+	if lob.OrderBook != nil {
+		status.Sequence = lob.Sequence
+		status.BidDepth = lob.Bids.Len()
+		status.AskDepth = lob.Asks.Len()
+	}
+	return status
 }
 
 // DroppedMessageCount return the total number of messages dropped since the
@@ -109,93 +302,139 @@ loop:
 	for {
 		select {
 		case a := <-lob.actionChan:
-			if err := lob.do(a); err != nil {
-				lob.ErrorChan <- err
+			for a != "" {
+				var err error
+				if a, err = lob.do(a); err != nil {
+					lob.ErrorChan <- err
+				}
 			}
 		case <-done:
 			break loop
+		case <-lob.closed:
+			break loop
 		}
 	}
 }
 
-// performs actions and manages state transitions
-func (lob *LiveOrderBook) do(a liveOrderBookAction) error {
-	switch lob.state {
-	case newState:
-		if a != resetAction {
-			break
-		}
+func (lob *LiveOrderBook) setState(state liveOrderBookState) {
+	lob.Lock()
+	// a closed book stays closed, even if it was in the middle of an action
+	if lob.state != closedState {
+		lob.state = state
+	}
+	lob.notifyChanged()
+	lob.Unlock()
+}
 
-		if err := lob.doReset(); err != nil {
-			return err
-		}
+// Changed returns a channel that is closed the next time the book changes,
+// either from a feed message or because it is reset or finishes loading. A
+// book that is no longer running can't be quoted from.
+func (lob *LiveOrderBook) Changed() <-chan struct{} {
+	lob.Lock()
+	defer lob.Unlock()
+	if lob.changed == nil {
+		lob.changed = make(chan struct{})
+	}
+	return lob.changed
+}
 
-		lob.Lock()
-		lob.state = loadingState
-		lob.Unlock()
+// notifyChanged wakes anyone waiting for the book to change. the lock must be
+// held
+func (lob *LiveOrderBook) notifyChanged() {
+	if lob.changed != nil {
+		close(lob.changed)
+		lob.changed = nil
+	}
+}
 
-		lob.actionChan <- synchronizeAction
+// performs actions and manages state transitions, returning the action that
+// should follow. a reset is valid from any state, the other actions only
+// advance the state they follow from
+func (lob *LiveOrderBook) do(a liveOrderBookAction) (liveOrderBookAction, error) {
+	lob.RLock()
+	state := lob.state
+	lob.RUnlock()
 
-	case loadingState:
-		if a != synchronizeAction {
-			break
-		}
+	switch a {
+	case resetAction:
+		lob.setState(newState)
 
-		lob.Lock()
-		lob.state = synchronizingState
-		lob.Unlock()
+		// without a feed the book would only ever be as new as its snapshot.
+		// while the feed is down the book is reset again once it reconnects
+		if lob.subscription != nil && lob.subscription.Stats().Disconnected || lob.isFeedDown() {
+			return "", nil
+		}
 
-		if err := lob.doSynchronize(); err != nil {
-			return err
+		if err := lob.doReset(); err != nil {
+			time.AfterFunc(resetRetryDelay, lob.Reset)
+			return "", err
 		}
 
-		lob.actionChan <- runAction
+		lob.setState(loadingState)
+		return synchronizeAction, nil
 
-	case synchronizingState:
-		if a != runAction {
+	case synchronizeAction:
+		if state != loadingState {
 			break
 		}
 
-		lob.Lock()
-		lob.state = runningState
-		lob.Unlock()
+		lob.setState(synchronizingState)
 
-	case runningState:
-		if a != resetAction {
-			break
+		if err := lob.doSynchronize(); err != nil {
+			return "", err
 		}
 
-		lob.Lock()
-		lob.state = newState
-		lob.OrderBook = nil
-		lob.Unlock()
+		return runAction, nil
 
-		if err := lob.doReset(); err != nil {
-			return err
+	case runAction:
+		if state != synchronizingState {
+			break
 		}
 
-		lob.Lock()
-		lob.state = loadingState
-		lob.Unlock()
-
-		lob.actionChan <- synchronizeAction
+		if err := lob.doRun(); err != nil {
+			return "", err
+		}
 	}
 
-	return nil
+	return "", nil
 }
 
+// doReset loads a new snapshot. the queue is kept, less the messages the
+// snapshot already includes, so a snapshot that lags the feed is still caught
+// up
 func (lob *LiveOrderBook) doReset() error {
 	lob.Lock()
-	lob.queue = []Message{}
 	lob.OrderBook = nil
 	lob.droppedMessages = 0
+	lob.resets++
 	lob.Unlock()
 
-	orderbook, err := lob.api.GetOrderBook(lob.client, lob.context, lob.productID, 3)
+	// level 2 messages have no sequence, so the ones the snapshot includes
+	// are told apart by having been queued before it was requested
+	lob.queueLock.RLock()
+	requested := len(lob.queue)
+	lob.queueLock.RUnlock()
+
+	orderbook, err := lob.loadSnapshot()
 	if err != nil {
 		return err
 	}
 
+	lob.queueLock.Lock()
+	defer lob.queueLock.Unlock()
+
+	kept := []Message{}
+	for i, m := range lob.queue {
+		h := m.Header()
+		switch {
+		case h.ProductID != lob.productID:
+		case lob.level == 2 && i < requested:
+		case lob.level == 2 || h.Sequence > orderbook.Sequence:
+			kept = append(kept, m)
+		}
+	}
+	lob.queue = kept
+
 	lob.Lock()
 	lob.OrderBook = orderbook
 	lob.Unlock()
@@ -220,127 +459,255 @@ func (lob *LiveOrderBook) doSynchronize() error {
 	return nil
 }
 
-// listens for events from GDAX feed and dispatches
-func (lob *LiveOrderBook) listen(feed *Feed) {
-	messageChan := make(chan Message)
-	feed.Subscribe(messageChan)
+// doRun applies anything queued since synchronizing finished and marks the
+// book running. the queue stays locked throughout so that no message can be
+// enqueued after the final drain
+func (lob *LiveOrderBook) doRun() error {
+	lob.queueLock.Lock()
+	defer lob.queueLock.Unlock()
+
+	for _, m := range lob.queue {
+		if err := lob.handle(m); err != nil {
+			return err
+		}
+	}
+	lob.queue = []Message{}
+
+	// the feed may have dropped while the book was loading, and then it waits
+	// to be reset once the feed reconnects
+	lob.Lock()
+	defer lob.Unlock()
+	if lob.state != closedState {
+		lob.state = runningState
+		if lob.feedDown {
+			lob.state = newState
+		}
+	}
+	lob.notifyChanged()
+	return nil
+}
 
+// setFeedDown records whether the feed is disconnected. a book whose feed is
+// down isn't running
+func (lob *LiveOrderBook) setFeedDown(down bool) {
+	lob.Lock()
+	defer lob.Unlock()
+	lob.feedDown = down
+	if down && lob.state != closedState {
+		lob.state = newState
+		lob.notifyChanged()
+	}
+}
+
+func (lob *LiveOrderBook) isFeedDown() bool {
+	lob.RLock()
+	defer lob.RUnlock()
+	return lob.feedDown
+}
+
+// listens for events from GDAX feed and dispatches
+func (lob *LiveOrderBook) listen(messageChan <-chan Message) {
 	for m := range messageChan {
-		lob.RLock()
-		state := lob.state
-		lob.RUnlock()
-
-		switch state {
-		case newState, loadingState, synchronizingState:
-			lob.enqueue(m)
-		case runningState:
-			if err := lob.handle(m); err != nil {
-				lob.ErrorChan <- err
-			}
+		switch m.(type) {
+		// the book can't be quoted from until the feed reconnects
+		case Disconnected:
+			lob.setFeedDown(true)
+			continue
+
+		// the feed reconnected, or the book fell behind it, so messages were
+		// probably missed and the book has to be reloaded
+		case Reconnected:
+			lob.setFeedDown(false)
+			lob.Reset()
+			continue
+		case Gap:
+			lob.Reset()
+			continue
+
+		// GDAX rejected something the feed sent it, such as a subscription.
+		// it isn't about the book, so it's left to whoever watches the feed
+		case Error:
+			continue
+		}
+
+		if lob.enqueue(m) {
+			continue
+		}
+
+		if err := lob.handle(m); err != nil {
+			lob.ErrorChan <- err
 		}
 	}
 
+	// the feed gave up on the book for falling behind, it can't be kept up
+	// to date any more
+	if lob.subscription != nil && lob.subscription.Stats().Disconnected {
+		lob.setState(newState)
+		lob.ErrorChan <- fmt.Errorf("%s: disconnected from the feed for falling behind",
+			lob.productID)
+	}
 }
 
-// enqueue appends an event to the queue. this queue is used during
-// initialization to capture any events that occur while the initial order book
-// state is loading via HTTP
-func (lob *LiveOrderBook) enqueue(m Message) {
+// enqueue appends an event to the queue unless the book is running, and
+// reports whether it did. this queue is used during initialization to capture
+// any events that occur while the initial order book state is loading via HTTP
+func (lob *LiveOrderBook) enqueue(m Message) bool {
 	lob.queueLock.Lock()
+	defer lob.queueLock.Unlock()
+
+	lob.RLock()
+	state := lob.state
+	lob.RUnlock()
+
+	if state == runningState {
+		return false
+	}
+
+	if len(lob.queue) >= maxQueuedMessages {
+		fmt.Fprintf(os.Stderr, "%s: discarding %d queued messages, resetting\n",
+			lob.productID, len(lob.queue))
+		lob.queue = []Message{}
+		lob.Reset()
+		return true
+	}
+
 	lob.queue = append(lob.queue, m)
-	lob.queueLock.Unlock()
+	return true
 }
 
-// only this function should access the sequence number to avoid locking
+// handle applies a message to the order book. if more messages have been
+// dropped than the gap tolerance allows, the book is marked unavailable and a
+// reset is requested
 func (lob *LiveOrderBook) handle(m Message) error {
-	// throw out irrelevant messages
-	if m.ProductID != lob.productID {
+	// only the book's product is subscribed to, but messages for no product
+	// in particular, such as errors, are sent too
+	h := m.Header()
+	if h.ProductID != lob.productID {
+		return nil
+	}
+
+	lob.Lock()
+	defer lob.Unlock()
+
+	// the book is being reset
+	if lob.OrderBook == nil {
 		return nil
 	}
+	defer lob.notifyChanged()
+
+	if lob.level == 2 {
+		return lob.handleLevel2(m)
+	}
 
 	// throw out stale messages
-	if m.Sequence <= lob.Sequence {
+	if h.Sequence <= lob.Sequence {
 		return nil
 	}
 
 	// cache previous value, and advance internal sequence number
 	sequence := lob.Sequence
-	lob.Sequence = m.Sequence
+	lob.Sequence = h.Sequence
+	lob.lastMessageTime = time.Now()
 
 	// detect if we missed any messages and track how many
-	droppedMessages := m.Sequence - sequence - 1
+	droppedMessages := h.Sequence - sequence - 1
 	if droppedMessages > 0 {
+		lob.droppedMessages += droppedMessages
+		lob.totalDroppedMessages += droppedMessages
+		if lob.droppedMessages > lob.gapTolerance {
+			lob.state = newState
+			lob.Reset()
+			return fmt.Errorf("%s: dropped %v messages, resynchronizing",
+				lob.productID, lob.droppedMessages)
+		}
 		fmt.Fprintf(os.Stderr, "Dropped %v messages.\n", droppedMessages)
-		lob.Lock()
-		lob.droppedMessages += droppedMessages // TODO: reset if dropped messages?
-		lob.Unlock()
 	}
 
-	switch m.Type {
-	case "open":
+	switch m := m.(type) {
+	case Open:
 		return lob.handleOpen(m)
-	case "done":
+	case Done:
 		return lob.handleDone(m)
-	case "match":
+	case Match:
 		return lob.handleMatch(m)
-	case "change":
+	case Change:
 		return lob.handleChange(m)
 	}
 
 	return nil
 }
 
-func (lob *LiveOrderBook) handleOpen(m Message) error {
-	price, err := strconv.ParseFloat(m.Price, 64)
-	if err != nil {
-		return fmt.Errorf("error parsing float from entry price (%s)\n", m.Price)
-	}
+// handleLevel2 applies a message from the level2 channel. these aren't
+// sequenced so gaps can't be detected. updates give the new total size at a
+// price rather than a change to it, so one from before the book's snapshot
+// would overwrite a newer size. doReset drops those that were queued before
+// the snapshot was requested
+func (lob *LiveOrderBook) handleLevel2(m Message) error {
+	switch m := m.(type) {
+	case Snapshot:
+		ob := NewOrderBook()
+		ob.Sequence = lob.Sequence
+		for _, side := range []struct {
+			name   string
+			levels []PriceLevel
+		}{{BidSide, m.Bids}, {AskSide, m.Asks}} {
+			for _, l := range side.levels {
+				if err := ob.SetLevel(side.name, l.Price, l.Size); err != nil {
+					return err
+				}
+			}
+		}
+		lob.OrderBook = ob
 
-	size, err := strconv.ParseFloat(m.RemainingSize, 64)
-	if err != nil {
-		return fmt.Errorf("error parsing float from entry remaining_size (%s)\n", m.RemainingSize)
-	}
+	case L2Update:
+		for _, c := range m.Changes {
+			if err := lob.SetLevel(c.Side, c.Price, c.Size); err != nil {
+				return err
+			}
+		}
 
-	lob.Lock()
-	defer lob.Unlock()
-	if err := lob.Insert(m.Side, price, size, m.OrderID); err != nil {
-		return err
+	default:
+		return nil
 	}
+
+	lob.lastMessageTime = time.Now()
 	return nil
 }
 
-func (lob *LiveOrderBook) handleMatch(m Message) error {
-	size, err := strconv.ParseFloat(m.Size, 64)
-	if err != nil {
-		return fmt.Errorf("error parsing float from entry size (%s)\n", m.Size)
+func (lob *LiveOrderBook) handleOpen(m Open) error {
+	if err := lob.Insert(m.Side, m.Price, m.RemainingSize, m.OrderID); err != nil {
+		return err
 	}
 
-	lob.Lock()
-	defer lob.Unlock()
-	if err := lob.Match(m.MakerOrderID, size); err != nil {
+	// only messages about our own orders carry our IDs
+	if len(m.UserID) > 0 || len(m.ProfileID) > 0 {
+		lob.SetOwn(m.OrderID)
+	}
+	return nil
+}
+
+func (lob *LiveOrderBook) handleMatch(m Match) error {
+	if err := lob.Match(m.MakerOrderID, m.Size); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (lob *LiveOrderBook) handleDone(m Message) error {
-	lob.Lock()
-	defer lob.Unlock()
+func (lob *LiveOrderBook) handleDone(m Done) error {
 	if err := lob.Delete(m.OrderID); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (lob *LiveOrderBook) handleChange(m Message) error {
-	size, err := strconv.ParseFloat(m.NewSize, 64)
-	if err != nil {
-		return fmt.Errorf("error parsing float from entry size (%s)\n", m.Size)
+// handleChange resizes an order on the book. market orders, which only have
+// their funds changed, are never on it
+func (lob *LiveOrderBook) handleChange(m Change) error {
+	if m.NewSize == nil {
+		return nil
 	}
 
-	lob.Lock()
-	defer lob.Unlock()
-	if err := lob.Change(m.OrderID, size); err != nil {
+	if err := lob.Change(m.OrderID, *m.NewSize); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,291 @@
+package gdax
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func makeLiveOrderBook() *LiveOrderBook {
+	return &LiveOrderBook{
+		RWMutex:    &sync.RWMutex{},
+		OrderBook:  makeOrderBook(),
+		productID:  "LTC-USD",
+		level:      3,
+		state:      runningState,
+		queueLock:  &sync.RWMutex{},
+		actionChan: make(chan liveOrderBookAction, 1),
+	}
+}
+
+// parse decodes a feed message, failing the test if it's malformed
+func parse(t *testing.T, raw string) Message {
+	t.Helper()
+	m, err := ParseMessage([]byte(raw))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return m
+}
+
+func TestHandleGapResets(t *testing.T) {
+	lob := makeLiveOrderBook()
+
+	if err := lob.handle(parse(t, `{"type":"done","sequence":1,
+		"product_id":"LTC-USD","order_id":"order-a"}`)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err := lob.handle(parse(t, `{"type":"done","sequence":3,
+		"product_id":"LTC-USD","order_id":"order-b"}`)); err == nil {
+		t.Errorf("a sequence gap should be reported")
+	}
+
+	if a := <-lob.actionChan; a != resetAction {
+		t.Errorf("a sequence gap should request a reset, got %s", a)
+	}
+
+	if _, err := lob.Quote(BuyAction, d("1"), false); err != ErrBookUnavailable {
+		t.Errorf("quotes should be rejected while resynchronizing, got %v", err)
+	}
+}
+
+func TestHandleGapTolerance(t *testing.T) {
+	lob := makeLiveOrderBook()
+	lob.SetGapTolerance(2)
+
+	if err := lob.handle(parse(t, `{"type":"done","sequence":3,
+		"product_id":"LTC-USD","order_id":"order-a"}`)); err != nil {
+		t.Errorf("a tolerated gap shouldn't be reported, got %s", err)
+	}
+
+	if lob.DroppedMessageCount() != 2 {
+		t.Errorf("expected 2 dropped messages, got %d", lob.DroppedMessageCount())
+	}
+
+	if _, err := lob.Quote(BuyAction, d("1"), false); err != nil {
+		t.Errorf("quotes should still be served, got %s", err)
+	}
+}
+
+func TestHandleLevel2(t *testing.T) {
+	lob := makeLiveOrderBook()
+	lob.level = 2
+
+	if err := lob.handle(parse(t, `{"type":"snapshot","product_id":"LTC-USD",
+		"bids":[["49.97","10"],["49.96","5"]],
+		"asks":[["50.01","2"],["50.06","4"]]}`)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err := lob.handle(parse(t, `{"type":"l2update","product_id":"LTC-USD",
+		"changes":[
+			["sell","50.01","0"],
+			["sell","50.06","3"],
+			["sell","50.03","1"],
+			["buy","49.98","1"]]}`)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if lob.Asks.Levels() != 2 || lob.Bids.Levels() != 3 {
+		t.Errorf("expected 3 bid and 2 ask levels, got %d and %d",
+			lob.Bids.Levels(), lob.Asks.Levels())
+	}
+
+	q, err := lob.Quote(BuyAction, d("2"), false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if expected := d("100.09"); q.Funds.Cmp(expected) != 0 {
+		t.Errorf("expected funds %s, got %s", expected, q.Funds)
+	}
+}
+
+func TestResetDropsStaleLevel2Updates(t *testing.T) {
+	lob := makeLiveOrderBook()
+	lob.level = 2
+	lob.state = newState
+
+	// queued before the snapshot is requested, so the snapshot is newer
+	lob.enqueue(parse(t, `{"type":"l2update","product_id":"LTC-USD",
+		"changes":[["buy","49.97","7"]]}`))
+	lob.loadSnapshot = func() (*OrderBook, error) {
+		// queued while the snapshot loads, so it may be newer
+		lob.enqueue(parse(t, `{"type":"l2update","product_id":"LTC-USD",
+			"changes":[["buy","49.96","5"]]}`))
+
+		ob := NewOrderBook()
+		ob.SetLevel(BidSide, d("49.97"), d("10"))
+		return ob, nil
+	}
+
+	if err := lob.doReset(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := lob.doSynchronize(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	q, err := lob.OrderBook.Quote(SellAction, d("15"), false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if expected := d("749.5"); q.Funds.Cmp(expected) != 0 {
+		t.Errorf("expected the snapshot's 10 at 49.97 and 5 at 49.96, funds %s, got %s",
+			expected, q.Funds)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	lob := makeLiveOrderBook()
+	changed := lob.Changed()
+
+	if err := lob.handle(parse(t, `{"type":"done","sequence":1,
+		"product_id":"LTC-USD","order_id":"order-a"}`)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Errorf("applying a message should close the changed channel")
+	}
+
+	changed = lob.Changed()
+	lob.setState(newState)
+	select {
+	case <-changed:
+	default:
+		t.Errorf("a change of state should close the changed channel")
+	}
+}
+
+// fanOutSource is a MessageSource that messages can be delivered through
+// directly
+type fanOutSource struct {
+	*fanOut
+}
+
+func (s fanOutSource) Subscribe(c chan Message, filter Filter) *Subscription {
+	return s.subscribe(c, filter, DefaultBufferSize, DropOnOverflow)
+}
+
+func TestCloseStopsTheBook(t *testing.T) {
+	source := fanOutSource{newFanOut()}
+	loadSnapshot := func() (*OrderBook, error) { return makeOrderBook(), nil }
+	lob := newLiveOrderBook(source, loadSnapshot, "LTC-USD", 3, make(chan struct{}))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !lob.Status().Running {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the book to load")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	lob.Close()
+	if _, err := lob.Quote(BuyAction, d("1"), false); err != ErrBookUnavailable {
+		t.Errorf("a closed book shouldn't be quoted from, got %v", err)
+	}
+	if routed := source.route(MessageHeader{Type: OpenMessage, ProductID: "LTC-USD"}); len(routed) != 0 {
+		t.Errorf("a closed book should be unsubscribed from its source")
+	}
+
+	select {
+	case _, ok := <-lob.ErrorChan:
+		if ok {
+			t.Errorf("no errors were expected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("the error channel should be closed once the book stops")
+	}
+}
+
+func TestFeedErrorsArentBookErrors(t *testing.T) {
+	source := fanOutSource{newFanOut()}
+	loadSnapshot := func() (*OrderBook, error) { return makeOrderBook(), nil }
+	lob := newLiveOrderBook(source, loadSnapshot, "LTC-USD", 3, make(chan struct{}))
+
+	// an error for the whole feed is reported once by whoever watches it,
+	// not by each book
+	source.deliver(parse(t, `{"type":"error","message":"Failed to subscribe"}`))
+	lob.Close()
+	for err := range lob.ErrorChan {
+		t.Errorf("no errors were expected, got %v", err)
+	}
+}
+
+func TestDisconnectedFeedStopsTheBook(t *testing.T) {
+	source := fanOutSource{newFanOut()}
+	loadSnapshot := func() (*OrderBook, error) { return makeOrderBook(), nil }
+	lob := newLiveOrderBook(source, loadSnapshot, "LTC-USD", 3, make(chan struct{}))
+	defer lob.Close()
+
+	waitUntil := func(running bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for lob.Status().Running != running {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for the book to be running: %t", running)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitUntil(true)
+
+	// the book stops being quoted as soon as the feed drops, not once it
+	// reconnects
+	source.deliver(Disconnected{MessageHeader{Type: DisconnectedMessage}})
+	waitUntil(false)
+	lob.Reset()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := lob.Quote(BuyAction, d("1"), false); err != ErrBookUnavailable {
+		t.Errorf("a book whose feed is down shouldn't be quoted from, got %v", err)
+	}
+
+	source.deliver(Reconnected{MessageHeader{Type: ReconnectedMessage}})
+	waitUntil(true)
+}
+
+func TestResetDropsStaleQueuedMessages(t *testing.T) {
+	lob := makeLiveOrderBook()
+	lob.state = newState
+	lob.loadSnapshot = func() (*OrderBook, error) {
+		ob := makeOrderBook()
+		ob.Sequence = 3
+		return ob, nil
+	}
+	for i := int64(1); i <= 5; i++ {
+		lob.enqueue(MessageHeader{Type: OpenMessage, ProductID: "LTC-USD", Sequence: i})
+	}
+
+	if err := lob.doReset(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(lob.queue) != 2 || lob.queue[0].Header().Sequence != 4 {
+		t.Errorf("expected only messages 4 and 5 to be kept, got %+v", lob.queue)
+	}
+}
+
+func TestQueueIsCapped(t *testing.T) {
+	defer func(n int) { maxQueuedMessages = n }(maxQueuedMessages)
+	maxQueuedMessages = 3
+
+	lob := makeLiveOrderBook()
+	lob.state = newState
+	for i := int64(1); i <= 4; i++ {
+		lob.enqueue(MessageHeader{Type: OpenMessage, ProductID: "LTC-USD", Sequence: i})
+	}
+
+	if len(lob.queue) != 0 {
+		t.Errorf("a full queue should be discarded, got %d messages", len(lob.queue))
+	}
+	select {
+	case a := <-lob.actionChan:
+		if a != resetAction {
+			t.Errorf("expected a reset, got %s", a)
+		}
+	default:
+		t.Errorf("discarding the queue should reset the book")
+	}
+}
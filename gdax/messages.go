@@ -0,0 +1,454 @@
+package gdax
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	ReceivedMessage            = "received"
+	OpenMessage                = "open"
+	DoneMessage                = "done"
+	MatchMessage               = "match"
+	ChangeMessage              = "change"
+	MarginProfileUpdateMessage = "margin_profile_update"
+	HeartbeatMessage           = "heartbeat"
+	ErrorMessage               = "error"
+	SubscriptionsMessage       = "subscriptions"
+
+	// SnapshotMessage and L2UpdateMessage are sent on the level2 channel
+	SnapshotMessage = "snapshot"
+	L2UpdateMessage = "l2update"
+
+	// DisconnectedMessage and ReconnectedMessage aren't sent by GDAX. Feed
+	// sends them to subscribers as soon as its connection drops, and after
+	// re-establishing it
+	DisconnectedMessage = "disconnected"
+	ReconnectedMessage  = "reconnected"
+
+	// GapMessage isn't sent by GDAX either. Feed sends it to a subscriber in
+	// place of the messages it dropped because the subscriber fell behind
+	GapMessage = "gap"
+)
+
+// Message is a decoded feed message: a Received, Open, Done, Match, Change,
+// Heartbeat, Error, Subscriptions, Snapshot or L2Update from GDAX, a
+// Disconnected, Reconnected or Gap from the feed itself, or Other for types
+// that aren't modelled. Subscribers tell them apart with a type switch.
+type Message interface {
+	Header() MessageHeader
+}
+
+// MessageHeader holds the fields messages of any type may have. Sequence is
+// only set on messages from the full channel, and Time is zero when GDAX
+// doesn't send one.
+type MessageHeader struct {
+	Type      string
+	ProductID string
+	Sequence  int64
+	Time      time.Time
+}
+
+// Header returns h, which makes every type that embeds it a Message
+func (h MessageHeader) Header() MessageHeader {
+	return h
+}
+
+// Received is sent when the matching engine accepts an order. Limit orders
+// have a Size and Price, market orders a Size or Funds.
+type Received struct {
+	MessageHeader
+	OrderID       string
+	ClientOrderID string
+	Side          string
+	OrderType     string
+	Size          *Decimal
+	Price         *Decimal
+	Funds         *Decimal
+	UserID        string
+	ProfileID     string
+}
+
+// Open is sent when what's left of a limit order rests on the book
+type Open struct {
+	MessageHeader
+	OrderID       string
+	Side          string
+	Price         Decimal
+	RemainingSize Decimal
+	UserID        string
+	ProfileID     string
+}
+
+// Done is sent when an order leaves the book, or is finished without ever
+// resting on it. Price and RemainingSize are nil for market orders.
+type Done struct {
+	MessageHeader
+	OrderID       string
+	Side          string
+	Reason        string
+	Price         *Decimal
+	RemainingSize *Decimal
+	UserID        string
+	ProfileID     string
+}
+
+// Match is a trade between a taker order and a maker order on the book
+type Match struct {
+	MessageHeader
+	TradeID        int64
+	MakerOrderID   string
+	TakerOrderID   string
+	Side           string
+	Size           Decimal
+	Price          Decimal
+	UserID         string
+	ProfileID      string
+	TakerUserID    string
+	TakerProfileID string
+}
+
+// Change is sent when self-trade prevention shrinks an order. Limit orders
+// have a NewSize and OldSize, market orders may have NewFunds and OldFunds
+// instead, and no Price.
+type Change struct {
+	MessageHeader
+	OrderID   string
+	Side      string
+	Price     *Decimal
+	NewSize   *Decimal
+	OldSize   *Decimal
+	NewFunds  *Decimal
+	OldFunds  *Decimal
+	UserID    string
+	ProfileID string
+}
+
+// Heartbeat is sent every second for each product on the heartbeat channel
+type Heartbeat struct {
+	MessageHeader
+	LastTradeID int64
+}
+
+// Error is sent when GDAX rejects something the feed sent it, such as a
+// subscription to a product that doesn't exist. It's an error too, which
+// NewFeed returns when it's what the subscription is answered with.
+type Error struct {
+	MessageHeader
+	Message string
+	Reason  string
+}
+
+func (e Error) Error() string {
+	if len(e.Reason) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Message, e.Reason)
+}
+
+// Subscriptions confirms what the feed is subscribed to, in reply to a
+// subscribe or unsubscribe message
+type Subscriptions struct {
+	MessageHeader
+	Channels []Channel
+}
+
+// PriceLevel is the total size of the orders at a price
+type PriceLevel struct {
+	Price Decimal
+	Size  Decimal
+}
+
+// Snapshot is the first message for a product on the level2 channel, every
+// price level of its book
+type Snapshot struct {
+	MessageHeader
+	Bids []PriceLevel
+	Asks []PriceLevel
+}
+
+// LevelChange is the new total size at a price on one side of a book. A size
+// of zero means there are no orders left at the price.
+type LevelChange struct {
+	Side  string
+	Price Decimal
+	Size  Decimal
+}
+
+// L2Update changes price levels of a book on the level2 channel
+type L2Update struct {
+	MessageHeader
+	Changes []LevelChange
+}
+
+// Disconnected is sent by the feed as soon as its connection drops, before it
+// tries to reconnect
+type Disconnected struct {
+	MessageHeader
+}
+
+// Reconnected is sent by the feed after re-establishing a dropped connection
+type Reconnected struct {
+	MessageHeader
+}
+
+// Gap is sent by the feed in place of messages dropped for a subscriber that
+// fell behind
+type Gap struct {
+	MessageHeader
+}
+
+// Other is a message of a type that isn't modelled, such as
+// margin_profile_update, as it was received
+type Other struct {
+	MessageHeader
+	Raw json.RawMessage
+}
+
+// MalformedMessageError is returned for a feed message that can't be decoded,
+// with the message as it was received
+type MalformedMessageError struct {
+	Raw []byte
+	Err error
+}
+
+func (e *MalformedMessageError) Error() string {
+	return fmt.Sprintf("malformed feed message %s: %s", e.Raw, e.Err)
+}
+
+// wireMessage is a feed message as GDAX encodes it, with the fields of every
+// type, and prices, sizes and times as strings
+type wireMessage struct {
+	Type           string     `json:"type"`
+	Time           string     `json:"time"`
+	Sequence       int64      `json:"sequence"`
+	ProductID      string     `json:"product_id"`
+	OrderID        string     `json:"order_id"`
+	ClientOrderID  string     `json:"client_oid"`
+	Size           string     `json:"size"`
+	Price          string     `json:"price"`
+	Side           string     `json:"side"`
+	OrderType      string     `json:"order_type"`
+	Funds          string     `json:"funds"`
+	Reason         string     `json:"reason"`
+	RemainingSize  string     `json:"remaining_size"`
+	TradeID        int64      `json:"trade_id"`
+	MakerOrderID   string     `json:"maker_order_id"`
+	TakerOrderID   string     `json:"taker_order_id"`
+	UserID         string     `json:"user_id"`
+	ProfileID      string     `json:"profile_id"`
+	TakerUserID    string     `json:"taker_user_id"`
+	TakerProfileID string     `json:"taker_profile_id"`
+	NewSize        string     `json:"new_size"`
+	OldSize        string     `json:"old_size"`
+	NewFunds       string     `json:"new_funds"`
+	OldFunds       string     `json:"old_funds"`
+	LastTradeID    int64      `json:"last_trade_id"`
+	Message        string     `json:"message"`
+	Channels       []Channel  `json:"channels"`
+	Bids           [][]string `json:"bids"`
+	Asks           [][]string `json:"asks"`
+	Changes        [][]string `json:"changes"`
+}
+
+// ParseMessage decodes a feed message, parsing its prices, sizes and time. The
+// error, if any, is a *MalformedMessageError.
+func ParseMessage(raw []byte) (Message, error) {
+	var w wireMessage
+	if err := json.Unmarshal(raw, &w); err != nil {
+		return nil, &MalformedMessageError{raw, err}
+	}
+	if len(w.Type) == 0 {
+		return nil, &MalformedMessageError{raw, fmt.Errorf("missing type")}
+	}
+
+	p := &fieldParser{}
+	h := MessageHeader{
+		Type:      w.Type,
+		ProductID: w.ProductID,
+		Sequence:  w.Sequence,
+		Time:      p.time(w.Time),
+	}
+
+	var m Message
+	switch w.Type {
+	case ReceivedMessage:
+		m = Received{
+			MessageHeader: h,
+			OrderID:       w.OrderID,
+			ClientOrderID: w.ClientOrderID,
+			Side:          w.Side,
+			OrderType:     w.OrderType,
+			Size:          p.optionalDecimal("size", w.Size),
+			Price:         p.optionalDecimal("price", w.Price),
+			Funds:         p.optionalDecimal("funds", w.Funds),
+			UserID:        w.UserID,
+			ProfileID:     w.ProfileID,
+		}
+
+	case OpenMessage:
+		m = Open{
+			MessageHeader: h,
+			OrderID:       w.OrderID,
+			Side:          w.Side,
+			Price:         p.decimal("price", w.Price),
+			RemainingSize: p.decimal("remaining_size", w.RemainingSize),
+			UserID:        w.UserID,
+			ProfileID:     w.ProfileID,
+		}
+
+	case DoneMessage:
+		m = Done{
+			MessageHeader: h,
+			OrderID:       w.OrderID,
+			Side:          w.Side,
+			Reason:        w.Reason,
+			Price:         p.optionalDecimal("price", w.Price),
+			RemainingSize: p.optionalDecimal("remaining_size", w.RemainingSize),
+			UserID:        w.UserID,
+			ProfileID:     w.ProfileID,
+		}
+
+	case MatchMessage:
+		m = Match{
+			MessageHeader:  h,
+			TradeID:        w.TradeID,
+			MakerOrderID:   w.MakerOrderID,
+			TakerOrderID:   w.TakerOrderID,
+			Side:           w.Side,
+			Size:           p.decimal("size", w.Size),
+			Price:          p.decimal("price", w.Price),
+			UserID:         w.UserID,
+			ProfileID:      w.ProfileID,
+			TakerUserID:    w.TakerUserID,
+			TakerProfileID: w.TakerProfileID,
+		}
+
+	case ChangeMessage:
+		m = Change{
+			MessageHeader: h,
+			OrderID:       w.OrderID,
+			Side:          w.Side,
+			Price:         p.optionalDecimal("price", w.Price),
+			NewSize:       p.optionalDecimal("new_size", w.NewSize),
+			OldSize:       p.optionalDecimal("old_size", w.OldSize),
+			NewFunds:      p.optionalDecimal("new_funds", w.NewFunds),
+			OldFunds:      p.optionalDecimal("old_funds", w.OldFunds),
+			UserID:        w.UserID,
+			ProfileID:     w.ProfileID,
+		}
+
+	case HeartbeatMessage:
+		m = Heartbeat{MessageHeader: h, LastTradeID: w.LastTradeID}
+
+	case ErrorMessage:
+		m = Error{MessageHeader: h, Message: w.Message, Reason: w.Reason}
+
+	case SubscriptionsMessage:
+		m = Subscriptions{MessageHeader: h, Channels: w.Channels}
+
+	case SnapshotMessage:
+		m = Snapshot{
+			MessageHeader: h,
+			Bids:          p.levels("bids", w.Bids),
+			Asks:          p.levels("asks", w.Asks),
+		}
+
+	case L2UpdateMessage:
+		m = L2Update{MessageHeader: h, Changes: p.changes(w.Changes)}
+
+	case DisconnectedMessage:
+		m = Disconnected{h}
+
+	case ReconnectedMessage:
+		m = Reconnected{h}
+
+	case GapMessage:
+		m = Gap{h}
+
+	default:
+		m = Other{MessageHeader: h, Raw: append(json.RawMessage{}, raw...)}
+	}
+
+	if p.err != nil {
+		return nil, &MalformedMessageError{raw, p.err}
+	}
+	return m, nil
+}
+
+// fieldParser parses the fields of a message, keeping the first error so a
+// message can be parsed in one go and checked once
+type fieldParser struct {
+	err error
+}
+
+func (p *fieldParser) fail(format string, a ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, a...)
+	}
+}
+
+// decimal parses a field that must be present
+func (p *fieldParser) decimal(name, s string) Decimal {
+	if len(s) == 0 {
+		p.fail("missing %s", name)
+		return Decimal{}
+	}
+	d, err := NewDecimal(s)
+	if err != nil {
+		p.fail("%s: %s", name, err)
+	}
+	return d
+}
+
+// optionalDecimal parses a field that may be left out, returning nil if it is
+func (p *fieldParser) optionalDecimal(name, s string) *Decimal {
+	if len(s) == 0 {
+		return nil
+	}
+	d := p.decimal(name, s)
+	return &d
+}
+
+// time parses a timestamp, which is zero if it's left out
+func (p *fieldParser) time(s string) time.Time {
+	if len(s) == 0 {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		p.fail("time: %s", err)
+	}
+	return t
+}
+
+// levels parses the [price, size] pairs of a level2 snapshot
+func (p *fieldParser) levels(name string, levels [][]string) []PriceLevel {
+	parsed := make([]PriceLevel, 0, len(levels))
+	for _, l := range levels {
+		if len(l) < 2 {
+			p.fail("%s: expected [price, size], got %v", name, l)
+			return nil
+		}
+		parsed = append(parsed, PriceLevel{p.decimal(name, l[0]), p.decimal(name, l[1])})
+	}
+	return parsed
+}
+
+// changes parses the [side, price, size] updates of an l2update
+func (p *fieldParser) changes(changes [][]string) []LevelChange {
+	parsed := make([]LevelChange, 0, len(changes))
+	for _, c := range changes {
+		if len(c) < 3 {
+			p.fail("changes: expected [side, price, size], got %v", c)
+			return nil
+		}
+		parsed = append(parsed, LevelChange{
+			Side:  c[0],
+			Price: p.decimal("changes", c[1]),
+			Size:  p.decimal("changes", c[2]),
+		})
+	}
+	return parsed
+}
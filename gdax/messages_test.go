@@ -0,0 +1,77 @@
+package gdax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMessage(t *testing.T) {
+	m := parse(t, `{"type":"match","trade_id":10,"sequence":50,
+		"maker_order_id":"order-a","taker_order_id":"order-b",
+		"time":"2014-11-07T08:19:27.028459Z","product_id":"BTC-USD",
+		"size":"5.23512","price":"400.23","side":"sell"}`)
+	match, ok := m.(Match)
+	if !ok {
+		t.Fatalf("expected a match, got %T", m)
+	}
+	at := time.Date(2014, 11, 7, 8, 19, 27, 28459000, time.UTC)
+	if match.Sequence != 50 || match.ProductID != "BTC-USD" || !match.Time.Equal(at) {
+		t.Errorf("unexpected header %+v", match.MessageHeader)
+	}
+	if match.Size.Cmp(d("5.23512")) != 0 || match.Price.Cmp(d("400.23")) != 0 ||
+		match.MakerOrderID != "order-a" || match.TradeID != 10 {
+		t.Errorf("unexpected match %+v", match)
+	}
+
+	// a market order's change has funds and no size or price
+	m = parse(t, `{"type":"change","sequence":80,"order_id":"order-c",
+		"product_id":"BTC-USD","new_funds":"5.23512","old_funds":"12.2","side":"buy"}`)
+	change, ok := m.(Change)
+	if !ok {
+		t.Fatalf("expected a change, got %T", m)
+	}
+	if change.NewSize != nil || change.Price != nil || change.NewFunds.Cmp(d("5.23512")) != 0 {
+		t.Errorf("unexpected change %+v", change)
+	}
+
+	for raw, expected := range map[string]string{
+		`{"type":"received","order_id":"o","size":"1","price":"2"}`:         "Received",
+		`{"type":"open","order_id":"o","price":"1","remaining_size":"2"}`:   "Open",
+		`{"type":"done","order_id":"o","reason":"canceled"}`:                "Done",
+		`{"type":"heartbeat","last_trade_id":20}`:                           "Heartbeat",
+		`{"type":"error","message":"Failed to subscribe"}`:                  "Error",
+		`{"type":"subscriptions","channels":[{"name":"full"}]}`:             "Subscriptions",
+		`{"type":"snapshot","bids":[["1","2"]],"asks":[]}`:                  "Snapshot",
+		`{"type":"l2update","changes":[["buy","1","0"]]}`:                   "L2Update",
+		`{"type":"disconnected"}`:                                           "Disconnected",
+		`{"type":"reconnected"}`:                                            "Reconnected",
+		`{"type":"margin_profile_update","product_id":"BTC-USD","nonce":1}`: "Other",
+	} {
+		if actual := fmt.Sprintf("%T", parse(t, raw)); actual != "gdax."+expected {
+			t.Errorf("%s: expected gdax.%s, got %s", raw, expected, actual)
+		}
+	}
+}
+
+func TestParseMalformedMessage(t *testing.T) {
+	for _, raw := range []string{
+		`{"type":"open","order_id":"o","price":"1.x","remaining_size":"2"}`,
+		`{"type":"open","order_id":"o","price":"1"}`,
+		`{"type":"match","size":"1","price":"2","time":"yesterday"}`,
+		`{"type":"l2update","changes":[["buy","1"]]}`,
+		`{"sequence":1}`,
+		`["open"]`,
+	} {
+		_, err := ParseMessage([]byte(raw))
+		e, ok := err.(*MalformedMessageError)
+		if !ok {
+			t.Errorf("%s: expected a malformed message error, got %v", raw, err)
+			continue
+		}
+		if string(e.Raw) != raw || !strings.Contains(e.Error(), raw) {
+			t.Errorf("%s: the error should hold the raw message, got %s", raw, e)
+		}
+	}
+}
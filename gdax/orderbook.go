@@ -2,9 +2,9 @@ package gdax
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
-	"strconv"
+	"time"
 )
 
 const (
@@ -15,188 +15,267 @@ const (
 	BidSide = "buy"
 )
 
-// OrderBook contains a snapshot of limit orders on GDAX
+// ErrInsufficientDepth is returned when there aren't enough orders on the book
+// to fill the amount being quoted
+var ErrInsufficientDepth = errors.New("order book too shallow to fill order")
+
+// OrderBook contains a snapshot of limit orders on GDAX. Orders are indexed by
+// ID, so updating or removing one doesn't involve searching for it
 type OrderBook struct {
-	Sequence int64 `json:"sequence"`
+	Sequence int64
 	entries  map[string]*OrderBookEntry
 
-	Bids []*OrderBookEntry `json:"bids"`
-	Asks []*OrderBookEntry `json:"asks"`
+	Bids *BookSide
+	Asks *BookSide
+}
+
+// NewOrderBook returns an empty order book
+func NewOrderBook() *OrderBook {
+	return &OrderBook{
+		entries: map[string]*OrderBookEntry{},
+		Bids:    newBookSide(BidSide),
+		Asks:    newBookSide(AskSide),
+	}
 }
 
 // OrderBookEntry contains values found in 3-tuples in the API response. Go
 // doesn't handle polymorphism or non-homogenous slices so instead we use this
 // struct and some complex unmarshaling code
 type OrderBookEntry struct {
-	Price     float64 `json:"price"`
-	Size      float64 `json:"size"`
+	Price     Decimal `json:"price"`
+	Size      Decimal `json:"size"`
 	NumOrders float64 `json:"num_orders"`
 	OrderID   string  `json:"order_id"`
 
 	Side string `json:"-"`
+
+	// Own is set on our own orders, which quotes leave out
+	Own bool `json:"-"`
+
+	// the entry's place in its side of the book
+	level      *priceLevel
+	prev, next *OrderBookEntry
 }
 
 func (ob *OrderBook) Find(orderID string) *OrderBookEntry {
 	return ob.entries[orderID]
 }
 
-// Insert will add a new order into the book, maintaining the price-sorted
-// order of the entries
-func (ob *OrderBook) Insert(side string, price, size float64, orderID string) error {
-	return ob.mutateSide(side,
-		func(entries []*OrderBookEntry) []*OrderBookEntry {
-			var i int
-			var e *OrderBookEntry
-			for i, e = range entries {
-				if side == BuyAction && e.Price < price {
-					break
-				} else if side == SellAction && e.Price > price {
-					break
-				}
-			}
+// Insert will add a new order into the book, behind any other orders at the
+// same price. An order already in the book with the same ID is replaced
+func (ob *OrderBook) Insert(side string, price, size Decimal, orderID string) error {
+	if ob.entries == nil {
+		*ob = *NewOrderBook()
+	}
 
-			entry := OrderBookEntry{price, size, 1, orderID, side}
-			entries = append(entries[:i], append([]*OrderBookEntry{&entry}, entries[i:]...)...)
-			ob.entries[orderID] = &entry
-			return entries
-		})
-}
+	bs, err := ob.side(side)
+	if err != nil {
+		return err
+	}
 
-// Delete will remove the order with the specified ID from the order book,
-// shrinking the size by one
-func (ob *OrderBook) Delete(orderID string) error {
-	e := ob.entries[orderID]
-
-	return ob.mutateSide(e.Side,
-		func(entries []*OrderBookEntry) []*OrderBookEntry {
-			for i, e := range entries {
-				if e.OrderID == orderID {
-					entries = append(entries[:i], entries[i+1:]...)
-					break
-				}
-			}
-			return entries
-		})
-}
+	ob.Delete(orderID)
 
-// Match will subtract the matched size from an existing order. If the new size
-// reaches 0, the order will not be deleted because there will be a subsequent
-// "Delete" call that will do so.
-func (ob *OrderBook) Match(orderID string, size float64) error {
-	e := ob.entries[orderID]
-	return ob.mutateSide(e.Side,
-		func(entries []*OrderBookEntry) []*OrderBookEntry {
-			for _, e := range entries {
-				if e.OrderID == orderID {
-					e.Size = e.Size - size
-					break
-				}
-			}
-			return entries
-		})
+	entry := &OrderBookEntry{Price: price, Size: size, NumOrders: 1,
+		OrderID: orderID, Side: side}
+	bs.push(entry)
+	ob.entries[orderID] = entry
+	return nil
 }
 
-// Change updates the size of an order
-func (ob *OrderBook) Change(orderID string, size float64) error {
-	e := ob.entries[orderID]
-	return ob.mutateSide(e.Side,
-		func(entries []*OrderBookEntry) []*OrderBookEntry {
-			for _, e := range entries {
-				if e.OrderID == orderID {
-					e.Size = size
-					break
-				}
-			}
-			return entries
-		})
+// SetLevel sets the total size at a price in an aggregated, level 2 book,
+// removing the price level if size is zero. Level 2 books hold one entry per
+// price, with no order ID.
+func (ob *OrderBook) SetLevel(side string, price, size Decimal) error {
+	if ob.entries == nil {
+		*ob = *NewOrderBook()
+	}
+
+	bs, err := ob.side(side)
+	if err != nil {
+		return err
+	}
+
+	l := bs.search(price, nil)
+	switch {
+	case l == nil && size.IsZero():
+	case l == nil:
+		bs.push(&OrderBookEntry{Price: price, Size: size, Side: side})
+	case size.IsZero():
+		for l.first != nil {
+			bs.remove(l.first)
+		}
+	default:
+		l.first.Size = size
+	}
+	return nil
 }
 
-func (ob *OrderBook) mutateSide(
-	side string, fn func(entries []*OrderBookEntry) []*OrderBookEntry,
-) error {
-	var entries []*OrderBookEntry
+func (ob *OrderBook) side(side string) (*BookSide, error) {
 	switch side {
 	case BidSide:
-		entries = ob.Bids
+		return ob.Bids, nil
 	case AskSide:
-		entries = ob.Asks
+		return ob.Asks, nil
 	default:
-		return fmt.Errorf("Received invalid order book side, %s\n", side)
+		return nil, fmt.Errorf("Received invalid order book side, %s\n", side)
 	}
+}
 
-	entries = fn(entries)
+// SetOwn marks an order as one of our own, so that it isn't quoted from.
+// Orders that aren't in the book are ignored
+func (ob *OrderBook) SetOwn(orderID string) {
+	if e, ok := ob.entries[orderID]; ok {
+		e.Own = true
+	}
+}
 
-	switch side {
-	case BidSide:
-		ob.Bids = entries
-	case AskSide:
-		ob.Asks = entries
+// Delete will remove the order with the specified ID from the order book,
+// shrinking the size by one. Orders that aren't in the book are ignored, GDAX
+// sends "done" messages for orders that were filled without ever resting on
+// the book
+func (ob *OrderBook) Delete(orderID string) error {
+	e, ok := ob.entries[orderID]
+	if !ok {
+		return nil
 	}
+	delete(ob.entries, orderID)
 
+	if e.Side == BidSide {
+		ob.Bids.remove(e)
+	} else {
+		ob.Asks.remove(e)
+	}
+	return nil
+}
+
+// Match will subtract the matched size from an existing order. If the new size
+// reaches 0, the order will not be deleted because there will be a subsequent
+// "Delete" call that will do so.
+func (ob *OrderBook) Match(orderID string, size Decimal) error {
+	if e, ok := ob.entries[orderID]; ok {
+		e.Size = e.Size.Sub(size)
+	}
+	return nil
+}
+
+// Change updates the size of an order. Orders that aren't in the book are
+// ignored
+func (ob *OrderBook) Change(orderID string, size Decimal) error {
+	if e, ok := ob.entries[orderID]; ok {
+		e.Size = size
+	}
 	return nil
 }
 
+// Quote is the result of walking an order book to fill an order: the amount
+// of the product's base currency (Size) exchanged for an amount of its quote
+// currency (Funds). Both are exact, rounding is left to the caller.
+//
+// The rest describes the book the quote was walked from: its best prices,
+// which are zero for an empty side, and its sequence at the time. Our own
+// orders are left out of the quote and its best prices. Fills holds
+// the amount taken at each price level, best price first. Time is set by
+// LiveOrderBook.
+type Quote struct {
+	Size  Decimal
+	Funds Decimal
+
+	BestBid  Decimal
+	BestAsk  Decimal
+	Sequence int64
+	Time     time.Time
+	Fills    []Fill
+}
+
+// Fill is the amount of the base currency a quote takes at one price
+type Fill struct {
+	Price Decimal
+	Size  Decimal
+}
+
 // Quote tallies order book entries until the requested amount is met, then
-// subtracts any overage then returns it and the price
-func (ob *OrderBook) Quote(
-	action, currency string, amount float64, inverse bool,
-) (price, total float64, err error) {
-	var side []*OrderBookEntry
+// subtracts any overage from the last entry consumed. The amount is of the
+// product's base currency, or of its quote currency when funds is true, the
+// same way GDAX market orders take either a size or funds.
+func (ob *OrderBook) Quote(action string, amount Decimal, funds bool) (Quote, error) {
+	var side *BookSide
 	if action == BuyAction {
 		side = ob.Asks
 	} else if action == SellAction {
 		side = ob.Bids
 	} else {
-		return price, total, fmt.Errorf("invalid action %s", action)
+		return Quote{}, fmt.Errorf("invalid action %s", action)
 	}
 
-	// total order entries until the quote amount can be fulfilled
-	var quantity, lastPrice float64
-	for _, entry := range side {
-		lastPrice = entry.Price
-		total += entry.Price * entry.Size
-		quantity += entry.Size
-		var check float64
-		if inverse {
-			check = total
-		} else {
-			check = quantity
-		}
-		if check >= amount {
-			break
+	if amount.Sign() <= 0 {
+		return Quote{}, fmt.Errorf("invalid amount %s", amount)
+	}
+
+	filled := func(q Quote) Decimal {
+		if funds {
+			return q.Funds
 		}
+		return q.Size
 	}
 
-	var check float64
-	if inverse {
-		check = total
-	} else {
-		check = quantity
+	q := Quote{Sequence: ob.Sequence}
+	if best := bestOther(ob.Bids); best != nil {
+		q.BestBid = best.Price
 	}
-	if check < amount {
-		return price, total,
-			fmt.Errorf("not enough %s available to fill order", currency)
+	if best := bestOther(ob.Asks); best != nil {
+		q.BestAsk = best.Price
 	}
 
-	// subtract overage from total
-	overage := quantity - amount
-	quantity = quantity - overage
-	total = total - (overage * lastPrice)
-	price = total / quantity
+	// total order entries until the quote amount can be fulfilled
+	var lastLevel *priceLevel
+	side.Walk(func(entry *OrderBookEntry) bool {
+		if entry.Own {
+			return true
+		}
+		if entry.level == lastLevel {
+			last := &q.Fills[len(q.Fills)-1]
+			last.Size = last.Size.Add(entry.Size)
+		} else {
+			lastLevel = entry.level
+			q.Fills = append(q.Fills, Fill{entry.Price, entry.Size})
+		}
+		q.Funds = q.Funds.Add(entry.Price.Mul(entry.Size))
+		q.Size = q.Size.Add(entry.Size)
+		return filled(q).Cmp(amount) < 0
+	})
+
+	if filled(q).Cmp(amount) < 0 {
+		return Quote{}, ErrInsufficientDepth
+	}
 
-	// account for prices that are too precise for their currency
-	precision := CurrencyPrecision(currency)
-	roundPrice := round(price, precision)
-	difference := price - roundPrice
+	// subtract overage of the last entry consumed
+	last := &q.Fills[len(q.Fills)-1]
+	if funds {
+		overage := q.Funds.Sub(amount).Div(last.Price)
+		q.Size = q.Size.Sub(overage)
+		last.Size = last.Size.Sub(overage)
+		q.Funds = amount
+	} else {
+		overage := q.Size.Sub(amount)
+		q.Funds = q.Funds.Sub(overage.Mul(last.Price))
+		last.Size = last.Size.Sub(overage)
+		q.Size = amount
+	}
 
-	price = price - difference
-	total = round(price*quantity, precision)
-	return
+	return q, nil
 }
 
-func round(f float64, places int) float64 {
-	shift := math.Pow(10, float64(places))
-	return math.Floor(f*shift+.5) / shift
+// bestOther returns the best entry on a side that isn't our own order
+func bestOther(side *BookSide) *OrderBookEntry {
+	var best *OrderBookEntry
+	side.Walk(func(entry *OrderBookEntry) bool {
+		if entry.Own {
+			return true
+		}
+		best = entry
+		return false
+	})
+	return best
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface. The custom
@@ -213,23 +292,25 @@ func (ob *OrderBook) UnmarshalJSON(buf []byte) error {
 		return err
 	}
 
+	*ob = *NewOrderBook()
 	ob.Sequence = sob.Sequence
-	ob.entries = map[string]*OrderBookEntry{}
-
-	for _, b := range sob.Bids {
-		entry, err := newOrderBookEntry(b)
-		if err != nil {
-			return err
-		}
-		ob.Bids = append(ob.Bids, entry)
-	}
 
-	for _, b := range sob.Asks {
-		entry, err := newOrderBookEntry(b)
-		if err != nil {
-			return err
+	for _, side := range []struct {
+		name    string
+		entries [][]interface{}
+		bs      *BookSide
+	}{{BidSide, sob.Bids, ob.Bids}, {AskSide, sob.Asks, ob.Asks}} {
+		for _, b := range side.entries {
+			entry, err := newOrderBookEntry(b)
+			if err != nil {
+				return err
+			}
+			entry.Side = side.name
+			side.bs.push(entry)
+			if len(entry.OrderID) > 0 {
+				ob.entries[entry.OrderID] = entry
+			}
 		}
-		ob.Asks = append(ob.Asks, entry)
 	}
 
 	return nil
@@ -238,29 +319,33 @@ func (ob *OrderBook) UnmarshalJSON(buf []byte) error {
 func newOrderBookEntry(serverEntry []interface{},
 ) (*OrderBookEntry, error) {
 	entry := OrderBookEntry{}
+	if len(serverEntry) < 3 {
+		return nil, fmt.Errorf("Unable to parse order book entry")
+	}
+
 	price, ok := serverEntry[0].(string)
 	if !ok {
 		return nil, fmt.Errorf("API returned non-string for order price")
 	}
 
-	floatPrice, err := strconv.ParseFloat(price, 64)
+	decimalPrice, err := NewDecimal(price)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse price as floating point number: %s", price)
+		return nil, fmt.Errorf("Unable to parse price as decimal number: %s", price)
 	}
 
-	entry.Price = floatPrice
+	entry.Price = decimalPrice
 
 	size, ok := serverEntry[1].(string)
 	if !ok {
 		return nil, fmt.Errorf("API returned non-string for order size")
 	}
 
-	floatSize, err := strconv.ParseFloat(size, 64)
+	decimalSize, err := NewDecimal(size)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse size as floating point number: %s", size)
+		return nil, fmt.Errorf("Unable to parse size as decimal number: %s", size)
 	}
 
-	entry.Size = floatSize
+	entry.Size = decimalSize
 
 	switch v := serverEntry[2].(type) {
 	case float64:
@@ -6,28 +6,33 @@ import (
 	"testing"
 )
 
-func makeOrderBook() *OrderBook {
-	orderA := OrderBookEntry{49.97, 11.5, 0, "order-a", BidSide}
-	orderB := OrderBookEntry{49.96, 9.5, 0, "order-b", BidSide}
-	orderC := OrderBookEntry{49.92, 7.5, 0, "order-c", BidSide}
-	orderD := OrderBookEntry{49.89, 5.5, 0, "order-d", BidSide}
+// d parses a decimal literal, panicking if it's malformed
+func d(s string) Decimal {
+	v, err := NewDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
 
-	orderE := OrderBookEntry{50.01, 4.5, 0, "order-e", AskSide}
-	orderF := OrderBookEntry{50.06, 6.5, 0, "order-f", AskSide}
-	orderG := OrderBookEntry{50.13, 8.5, 0, "order-g", AskSide}
-	orderH := OrderBookEntry{50.26, 10.5, 0, "order-h", AskSide}
+func makeOrderBook() *OrderBook {
+	ob := NewOrderBook()
+	for _, e := range []struct {
+		side, price, size, id string
+	}{
+		{BidSide, "49.97", "11.5", "order-a"},
+		{BidSide, "49.96", "9.5", "order-b"},
+		{BidSide, "49.92", "7.5", "order-c"},
+		{BidSide, "49.89", "5.5", "order-d"},
 
-	return &OrderBook{
-		Sequence: 0,
-		entries: map[string]*OrderBookEntry{
-			"order-a": &orderA, "order-b": &orderB,
-			"order-c": &orderC, "order-d": &orderD,
-			"order-e": &orderE, "order-f": &orderF,
-			"order-g": &orderG, "order-h": &orderH,
-		},
-		Bids: []*OrderBookEntry{&orderA, &orderB, &orderC, &orderD},
-		Asks: []*OrderBookEntry{&orderE, &orderF, &orderG, &orderH},
+		{AskSide, "50.01", "4.5", "order-e"},
+		{AskSide, "50.06", "6.5", "order-f"},
+		{AskSide, "50.13", "8.5", "order-g"},
+		{AskSide, "50.26", "10.5", "order-h"},
+	} {
+		ob.Insert(e.side, d(e.price), d(e.size), e.id)
 	}
+	return ob
 }
 
 func TestFind(t *testing.T) {
@@ -36,10 +41,10 @@ func TestFind(t *testing.T) {
 	if e == nil {
 		t.Fatalf("could not find order")
 	}
-	if e.Price != 50.06 {
+	if e.Price.Cmp(d("50.06")) != 0 {
 		t.Errorf("incorrect price, wanted %v, received %v", 50.06, e.Price)
 	}
-	if e.Size != 6.5 {
+	if e.Size.Cmp(d("6.5")) != 0 {
 		t.Errorf("incorrect size, wanted %v, received %v", 6.5, e.Size)
 	}
 }
@@ -49,51 +54,72 @@ func TestInsert(t *testing.T) {
 
 	for _, e := range []struct {
 		side  string
-		price float64
-		size  float64
+		price Decimal
+		size  Decimal
 		id    string
 	}{
-		{BidSide, 49.98, 10.2, "order-4"},
-		{AskSide, 50.03, 13.3, "order-2"},
-		{AskSide, 50.00, 5.3, "order-3"},
-		{BidSide, 49.95, 13.3, "order-5"},
-		{AskSide, 50.05, 10.2, "order-1"},
-		{BidSide, 49.90, 5.3, "order-6"},
+		{BidSide, d("49.98"), d("10.2"), "order-4"},
+		{AskSide, d("50.03"), d("13.3"), "order-2"},
+		{AskSide, d("50.00"), d("5.3"), "order-3"},
+		{BidSide, d("49.95"), d("13.3"), "order-5"},
+		{AskSide, d("50.05"), d("10.2"), "order-1"},
+		{BidSide, d("49.90"), d("5.3"), "order-6"},
 	} {
 		if err := ob.Insert(e.side, e.price, e.size, e.id); err != nil {
 			t.Errorf("%s", err)
 		}
 	}
 
-	if len(ob.Bids) != 7 {
+	if ob.Bids.Len() != 7 {
 		t.Errorf("Failed to insert 3 bids")
 	}
 
-	if len(ob.Asks) != 7 {
+	if ob.Asks.Len() != 7 {
 		t.Errorf("Failed to insert 3 asks")
 	}
 
-	last := ob.Bids[0]
-	for _, e := range ob.Bids[1:] {
-		if e.Price >= last.Price {
+	bids := ob.Bids.Entries()
+	last := bids[0]
+	for _, e := range bids[1:] {
+		if e.Price.Cmp(last.Price) >= 0 {
 			t.Errorf("Bids aren't sorted")
 		}
 		last = e
 	}
 
-	last = ob.Asks[0]
-	for _, e := range ob.Asks[1:] {
-		if e.Price <= last.Price {
+	asks := ob.Asks.Entries()
+	last = asks[0]
+	for _, e := range asks[1:] {
+		if e.Price.Cmp(last.Price) <= 0 {
 			t.Errorf("Asks aren't sorted")
 		}
 		last = e
 	}
 }
 
+func TestInsertOutsideBook(t *testing.T) {
+	ob := makeOrderBook()
+
+	ob.Insert(AskSide, d("50.50"), d("1"), "order-worst-ask")
+	ob.Insert(BidSide, d("49.50"), d("1"), "order-worst-bid")
+	ob.Insert(AskSide, d("49.99"), d("1"), "order-best-ask")
+
+	asks, bids := ob.Asks.Entries(), ob.Bids.Entries()
+	if asks[len(asks)-1].OrderID != "order-worst-ask" {
+		t.Errorf("the worst ask should be inserted last")
+	}
+	if bids[len(bids)-1].OrderID != "order-worst-bid" {
+		t.Errorf("the worst bid should be inserted last")
+	}
+	if ob.Asks.Best().OrderID != "order-best-ask" {
+		t.Errorf("the best ask should be inserted first")
+	}
+}
+
 func BenchmarkInsert(b *testing.B) {
 	ob := makeOrderBook()
 	for i := 0; i < b.N; i++ {
-		ob.Insert(BidSide, rand.Float64(), rand.Float64(), strconv.Itoa(rand.Int()))
+		ob.Insert(BidSide, randomDecimal(), randomDecimal(), strconv.Itoa(rand.Int()))
 	}
 }
 
@@ -102,26 +128,58 @@ func TestDelete(t *testing.T) {
 
 	ob.Delete("order-b")
 
-	if len(ob.Bids) >= 4 {
+	if ob.Bids.Len() >= 4 {
 		t.Errorf("Failed to delete an order")
 	}
 
-	if len(ob.Asks) < 4 {
+	if ob.Asks.Len() < 4 {
 		t.Errorf("Deleted an Ask instead of a Bid")
 	}
 
-	for _, b := range ob.Bids {
+	for _, b := range ob.Bids.Entries() {
 		if b.OrderID == "order-b" {
 			t.Errorf("Failed to delete order-b")
 		}
 	}
 }
 
+func TestDeleteUnknown(t *testing.T) {
+	ob := makeOrderBook()
+
+	if err := ob.Delete("order-z"); err != nil {
+		t.Errorf("deleting an unknown order should be ignored, got %s", err)
+	}
+
+	if ob.Bids.Len() != 4 || ob.Asks.Len() != 4 {
+		t.Errorf("deleting an unknown order shouldn't change the book")
+	}
+}
+
+func TestUnmarshalIndexesOrders(t *testing.T) {
+	ob := OrderBook{}
+	err := ob.UnmarshalJSON([]byte(`{"sequence":3,
+		"bids":[["49.97","11.5","order-a"]],
+		"asks":[["50.01","4.5","order-e"]]}`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err := ob.Delete("order-a"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if ob.Bids.Len() != 0 {
+		t.Errorf("orders from a snapshot should be deletable")
+	}
+	if e := ob.Find("order-e"); e == nil || e.Side != AskSide {
+		t.Errorf("orders from a snapshot should be found with their side")
+	}
+}
+
 func BenchmarkInsertDelete(b *testing.B) {
 	ob := makeOrderBook()
 	for i := 0; i < b.N; i++ {
 		id := strconv.Itoa(rand.Int())
-		ob.Insert(BidSide, rand.Float64(), rand.Float64(), id)
+		ob.Insert(BidSide, randomDecimal(), randomDecimal(), id)
 		ob.Delete(id)
 	}
 }
@@ -129,9 +187,9 @@ func BenchmarkInsertDelete(b *testing.B) {
 func TestMatch(t *testing.T) {
 	ob := makeOrderBook()
 
-	ob.Match("order-b", 1.0)
+	ob.Match("order-b", d("1.0"))
 	e := ob.Find("order-b")
-	if e.Size != 8.5 {
+	if e.Size.Cmp(d("8.5")) != 0 {
 		t.Errorf("entry size should be 8.5, got %v", e.Size)
 	}
 }
@@ -139,9 +197,9 @@ func TestMatch(t *testing.T) {
 func TestChange(t *testing.T) {
 	ob := makeOrderBook()
 
-	ob.Change("order-b", 1.0)
+	ob.Change("order-b", d("1.0"))
 	e := ob.Find("order-b")
-	if e.Size != 1.0 {
+	if e.Size.Cmp(d("1.0")) != 0 {
 		t.Errorf("entry size should be 1.0, got %v", e.Size)
 	}
 }
@@ -149,11 +207,112 @@ func TestChange(t *testing.T) {
 func TestQuote(t *testing.T) {
 	ob := makeOrderBook()
 
-	price, total, _ := ob.Quote(BuyAction, "LTC", 2.0, false)
-	if price != 50.01 {
-		t.Errorf("Quote returned the wrong price")
+	q, _ := ob.Quote(BuyAction, d("2.0"), false)
+	if q.Size.Cmp(d("2")) != 0 {
+		t.Errorf("Quote returned the wrong size")
+	}
+	if q.Funds.Cmp(d("100.02")) != 0 {
+		t.Errorf("Quote returned the wrong funds")
+	}
+}
+
+func TestQuoteAcrossEntries(t *testing.T) {
+	ob := makeOrderBook()
+
+	// 4.5 @ 50.01 + 1.5 @ 50.06
+	q, err := ob.Quote(BuyAction, d("6"), false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if q.Funds.Cmp(d("300.135")) != 0 {
+		t.Errorf("Quote returned the wrong funds, got %s", q.Funds)
+	}
+}
+
+func TestQuoteFunds(t *testing.T) {
+	ob := makeOrderBook()
+
+	// 199.88 of the quote currency is exactly 4 at 49.97
+	q, err := ob.Quote(SellAction, d("199.88"), true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if q.Size.Cmp(d("4")) != 0 {
+		t.Errorf("Quote returned the wrong size, got %s", q.Size)
+	}
+	if q.Funds.Cmp(d("199.88")) != 0 {
+		t.Errorf("Quote returned the wrong funds, got %s", q.Funds)
+	}
+
+	// 300.08494 buys 4.5 @ 50.01 and 1.499 @ 50.06
+	q, err = ob.Quote(BuyAction, d("300.08494"), true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if q.Size.Cmp(d("5.999")) != 0 {
+		t.Errorf("Quote returned the wrong size, got %s", q.Size)
+	}
+}
+
+func TestQuoteFills(t *testing.T) {
+	ob := makeOrderBook()
+	ob.Sequence = 42
+	ob.Insert(AskSide, d("50.01"), d("1"), "e2")
+
+	// 5.5 @ 50.01, from two orders, and 0.5 @ 50.06
+	q, err := ob.Quote(BuyAction, d("6"), false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if q.BestBid.Cmp(d("49.97")) != 0 || q.BestAsk.Cmp(d("50.01")) != 0 {
+		t.Errorf("expected best prices 49.97 and 50.01, got %s and %s",
+			q.BestBid, q.BestAsk)
+	}
+	if q.Sequence != 42 {
+		t.Errorf("expected sequence 42, got %d", q.Sequence)
+	}
+
+	expected := []Fill{{d("50.01"), d("5.5")}, {d("50.06"), d("0.5")}}
+	if len(q.Fills) != len(expected) {
+		t.Fatalf("expected %d fills, got %v", len(expected), q.Fills)
+	}
+	for i, f := range expected {
+		if q.Fills[i].Price.Cmp(f.Price) != 0 || q.Fills[i].Size.Cmp(f.Size) != 0 {
+			t.Errorf("fill %d: expected %s @ %s, got %s @ %s",
+				i, f.Size, f.Price, q.Fills[i].Size, q.Fills[i].Price)
+		}
+	}
+}
+
+func TestQuoteSkipsOwnOrders(t *testing.T) {
+	ob := makeOrderBook()
+	ob.SetOwn("order-e")
+
+	// 4.5 @ 50.01 is ours, so 2 @ 50.06
+	q, err := ob.Quote(BuyAction, d("2"), false)
+	if err != nil {
+		t.Fatalf("%s", err)
 	}
-	if total != 100.02 {
-		t.Errorf("Quote returned the wrong total")
+	if q.Funds.Cmp(d("100.12")) != 0 {
+		t.Errorf("Quote returned the wrong funds, got %s", q.Funds)
 	}
+	if q.BestAsk.Cmp(d("50.06")) != 0 {
+		t.Errorf("expected the best ask to skip our order, got %s", q.BestAsk)
+	}
+}
+
+func TestQuoteInsufficientDepth(t *testing.T) {
+	ob := makeOrderBook()
+
+	if _, err := ob.Quote(BuyAction, d("1000"), false); err != ErrInsufficientDepth {
+		t.Errorf("Quote should fail when the book can't fill the amount, got %v", err)
+	}
+	if _, err := ob.Quote(BuyAction, d("1000000"), true); err != ErrInsufficientDepth {
+		t.Errorf("Quote should fail when the book can't fill the funds, got %v", err)
+	}
+}
+
+func randomDecimal() Decimal {
+	return d(strconv.FormatFloat(rand.Float64(), 'f', 8, 64))
 }
@@ -0,0 +1,128 @@
+package gdax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	ordersPath = "/orders"
+	fillsPath  = "/fills"
+
+	LimitOrder  = "limit"
+	MarketOrder = "market"
+)
+
+// Order is an order placed on the exchange, as listed by /orders. Side is
+// BuyAction or SellAction. Limit orders have a Price and Size, market orders
+// a Size or Funds.
+type Order struct {
+	ID            string    `json:"id"`
+	ProductID     string    `json:"product_id"`
+	Side          string    `json:"side"`
+	Type          string    `json:"type"`
+	Price         Decimal   `json:"price"`
+	Size          Decimal   `json:"size"`
+	Funds         Decimal   `json:"funds"`
+	TimeInForce   string    `json:"time_in_force"`
+	PostOnly      bool      `json:"post_only"`
+	CreatedAt     time.Time `json:"created_at"`
+	FillFees      Decimal   `json:"fill_fees"`
+	FilledSize    Decimal   `json:"filled_size"`
+	ExecutedValue Decimal   `json:"executed_value"`
+	Status        string    `json:"status"`
+	Settled       bool      `json:"settled"`
+}
+
+// OrderFill is a trade one of our orders took part in, as listed by /fills.
+// Liquidity is "M" when the order was the maker and "T" when it was the
+// taker, and Fee is in the product's quote currency.
+type OrderFill struct {
+	TradeID   int64     `json:"trade_id"`
+	ProductID string    `json:"product_id"`
+	OrderID   string    `json:"order_id"`
+	Side      string    `json:"side"`
+	Price     Decimal   `json:"price"`
+	Size      Decimal   `json:"size"`
+	Fee       Decimal   `json:"fee"`
+	Liquidity string    `json:"liquidity"`
+	CreatedAt time.Time `json:"created_at"`
+	Settled   bool      `json:"settled"`
+}
+
+// GetOrders lists our open orders, on every product when productID is empty.
+// Every page of orders is fetched.
+func (a API) GetOrders(c *http.Client, ctx context.Context, productID string) ([]Order, error) {
+	query := url.Values{}
+	if len(productID) > 0 {
+		query.Set("product_id", productID)
+	}
+
+	orders := []Order{}
+	err := a.GetPages(c, ctx, ordersPath, query, func(body []byte) (int, error) {
+		var page []Order
+		if err := json.Unmarshal(body, &page); err != nil {
+			return 0, fmt.Errorf("Error decoding orders: %s", err)
+		}
+		orders = append(orders, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetOrder fetches a single order by its ID
+func (a API) GetOrder(c *http.Client, ctx context.Context, id string) (*Order, error) {
+	body, err := a.Request(c, ctx, http.MethodGet, ordersPath+"/"+url.PathEscape(id), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var order Order
+	if err := json.Unmarshal(body, &order); err != nil {
+		return nil, fmt.Errorf("Error decoding order: %s", err)
+	}
+	return &order, nil
+}
+
+// GetFills lists the fills of our orders since a time, newest first, filtered
+// by order ID or product ID when they aren't empty. Pages are only fetched
+// until one reaches back before since, so a long history isn't paged through.
+// When since is zero every page is fetched.
+func (a API) GetFills(
+	c *http.Client, ctx context.Context, orderID, productID string, since time.Time,
+) ([]OrderFill, error) {
+	query := url.Values{}
+	if len(orderID) > 0 {
+		query.Set("order_id", orderID)
+	}
+	if len(productID) > 0 {
+		query.Set("product_id", productID)
+	}
+
+	fills := []OrderFill{}
+	err := a.GetPages(c, ctx, fillsPath, query, func(body []byte) (int, error) {
+		var page []OrderFill
+		if err := json.Unmarshal(body, &page); err != nil {
+			return 0, fmt.Errorf("Error decoding fills: %s", err)
+		}
+		for i, f := range page {
+			if f.CreatedAt.Before(since) {
+				fills = append(fills, page[:i]...)
+				return 0, nil
+			}
+		}
+		fills = append(fills, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fills, nil
+}
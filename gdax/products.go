@@ -0,0 +1,221 @@
+package gdax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+const (
+	productsPath   = "/products"
+	currenciesPath = "/currencies"
+
+	// ProductOnline is the status of products that are trading
+	ProductOnline = "online"
+)
+
+// Product contains the exchange's metadata for a product, as listed by
+// /products
+type Product struct {
+	ID             string  `json:"id"`
+	BaseCurrency   string  `json:"base_currency"`
+	QuoteCurrency  string  `json:"quote_currency"`
+	BaseMinSize    Decimal `json:"base_min_size"`
+	BaseMaxSize    Decimal `json:"base_max_size"`
+	QuoteIncrement Decimal `json:"quote_increment"`
+	Status         string  `json:"status"`
+}
+
+// PricePrecision returns the number of decimal places prices on the product
+// are quoted to
+func (p Product) PricePrecision() int {
+	return p.QuoteIncrement.places()
+}
+
+// CheckSize returns an error if an order for size of the product's base
+// currency is smaller or larger than the exchange allows
+func (p Product) CheckSize(size Decimal) error {
+	if size.Cmp(p.BaseMinSize) < 0 {
+		return fmt.Errorf("order size %s is below the minimum of %s",
+			size.StringFixed(p.BaseMinSize.places()), p.BaseMinSize)
+	}
+	if !p.BaseMaxSize.IsZero() && size.Cmp(p.BaseMaxSize) > 0 {
+		return fmt.Errorf("order size %s is above the maximum of %s",
+			size.StringFixed(p.BaseMinSize.places()), p.BaseMaxSize)
+	}
+	return nil
+}
+
+// Currency contains the exchange's metadata for a currency, as listed by
+// /currencies
+type Currency struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	MinSize Decimal `json:"min_size"`
+	Status  string  `json:"status"`
+}
+
+// Precision returns the number of decimal places amounts of the currency are
+// given to
+func (c Currency) Precision() int {
+	return c.MinSize.places()
+}
+
+// GetProducts lists the products traded on the exchange
+func (a API) GetProducts(c *http.Client, ctx context.Context) ([]Product, error) {
+	body, err := a.Request(c, ctx, http.MethodGet, productsPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	if err := json.Unmarshal(body, &products); err != nil {
+		return nil, fmt.Errorf("Error decoding products: %s", err)
+	}
+	return products, nil
+}
+
+// GetCurrencies lists the currencies known to the exchange
+func (a API) GetCurrencies(c *http.Client, ctx context.Context) ([]Currency, error) {
+	body, err := a.Request(c, ctx, http.MethodGet, currenciesPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var currencies []Currency
+	if err := json.Unmarshal(body, &currencies); err != nil {
+		return nil, fmt.Errorf("Error decoding currencies: %s", err)
+	}
+	return currencies, nil
+}
+
+// GetRegistry builds a Registry from the exchange's current products and
+// currencies
+func (a API) GetRegistry(c *http.Client, ctx context.Context) (*Registry, error) {
+	products, err := a.GetProducts(c, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies, err := a.GetCurrencies(c, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRegistry(products, currencies), nil
+}
+
+// Registry holds the products and currencies listed by the exchange, which
+// product IDs are validated against and currency precisions and currency pair
+// lookups come from. It isn't modified after it's created, so it's safe for
+// concurrent use.
+type Registry struct {
+	products   map[string]Product
+	currencies map[string]Currency
+}
+
+// NewRegistry builds a Registry from lists of products and currencies
+func NewRegistry(products []Product, currencies []Currency) *Registry {
+	r := &Registry{
+		products:   make(map[string]Product, len(products)),
+		currencies: make(map[string]Currency, len(currencies)),
+	}
+	for _, p := range products {
+		r.products[p.ID] = p
+	}
+	for _, c := range currencies {
+		r.currencies[c.ID] = c
+	}
+	return r
+}
+
+// Product looks up a product by ID
+func (r *Registry) Product(productID string) (Product, bool) {
+	p, ok := r.products[productID]
+	return p, ok
+}
+
+// Products returns every product, ordered by ID
+func (r *Registry) Products() []Product {
+	products := make([]Product, 0, len(r.products))
+	for _, p := range r.products {
+		products = append(products, p)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ID < products[j].ID
+	})
+	return products
+}
+
+// Currencies returns every currency, ordered by ID
+func (r *Registry) Currencies() []Currency {
+	currencies := make([]Currency, 0, len(r.currencies))
+	for _, c := range r.currencies {
+		currencies = append(currencies, c)
+	}
+	sort.Slice(currencies, func(i, j int) bool {
+		return currencies[i].ID < currencies[j].ID
+	})
+	return currencies
+}
+
+// OnlineProductIDs returns the IDs of the products that are trading, ordered
+func (r *Registry) OnlineProductIDs() []string {
+	var productIDs []string
+	for _, p := range r.Products() {
+		if p.Status == ProductOnline {
+			productIDs = append(productIDs, p.ID)
+		}
+	}
+	return productIDs
+}
+
+func (r *Registry) IsValidProductID(productID string) bool {
+	_, ok := r.products[productID]
+	return ok
+}
+
+// CurrencyPrecision returns the number of decimal places amounts of a currency
+// are given to, or -1 if the currency isn't known
+func (r *Registry) CurrencyPrecision(currency string) int {
+	c, ok := r.currencies[currency]
+	if !ok {
+		return -1
+	}
+	return c.Precision()
+}
+
+// ProductIDForCurrencyPair returns the ID of the product that trades a for b,
+// whichever of them is its base currency, or "" if there isn't one
+func (r *Registry) ProductIDForCurrencyPair(a, b string) string {
+	for _, p := range r.Products() {
+		if (p.BaseCurrency == a && p.QuoteCurrency == b) ||
+			(p.BaseCurrency == b && p.QuoteCurrency == a) {
+			return p.ID
+		}
+	}
+	return ""
+}
+
+type registryJSON struct {
+	Products   []Product  `json:"products"`
+	Currencies []Currency `json:"currencies"`
+}
+
+// MarshalJSON implements the json.Marshaler interface, so that a registry can
+// be recorded and replayed
+func (r *Registry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(registryJSON{r.Products(), r.Currencies()})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (r *Registry) UnmarshalJSON(buf []byte) error {
+	var rj registryJSON
+	if err := json.Unmarshal(buf, &rj); err != nil {
+		return err
+	}
+	*r = *NewRegistry(rj.Products, rj.Currencies)
+	return nil
+}
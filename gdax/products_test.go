@@ -0,0 +1,111 @@
+package gdax
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const productsJSON = `[
+	{"id":"BTC-USD","base_currency":"BTC","quote_currency":"USD",
+	 "base_min_size":"0.01","base_max_size":"250","quote_increment":"0.01",
+	 "display_name":"BTC/USD","status":"online"},
+	{"id":"ETH-BTC","base_currency":"ETH","quote_currency":"BTC",
+	 "base_min_size":"0.01","base_max_size":"600","quote_increment":"0.00001",
+	 "display_name":"ETH/BTC","status":"online"},
+	{"id":"BTC-GBP","base_currency":"BTC","quote_currency":"GBP",
+	 "base_min_size":"0.01","base_max_size":"250","quote_increment":"0.01",
+	 "display_name":"BTC/GBP","status":"offline"}
+]`
+
+const currenciesJSON = `[
+	{"id":"BTC","name":"Bitcoin","min_size":"0.00000001","status":"online"},
+	{"id":"ETH","name":"Ether","min_size":"0.00000001","status":"online"},
+	{"id":"GBP","name":"British Pound","min_size":"0.01","status":"online"},
+	{"id":"USD","name":"United States Dollar","min_size":"0.01","status":"online"}
+]`
+
+func makeRegistry(t *testing.T) *Registry {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(productsJSON))
+	})
+	mux.HandleFunc("/currencies", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(currenciesJSON))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	registry, err := API{URL: ts.URL}.GetRegistry(http.DefaultClient, context.Background())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return registry
+}
+
+func TestRegistry(t *testing.T) {
+	r := makeRegistry(t)
+
+	if !r.IsValidProductID("ETH-BTC") || r.IsValidProductID("LTC-BTC") {
+		t.Errorf("only listed products should be valid")
+	}
+
+	if ids := r.OnlineProductIDs(); !reflect.DeepEqual(ids, []string{"BTC-USD", "ETH-BTC"}) {
+		t.Errorf("expected the online products, got %v", ids)
+	}
+
+	for currency, expected := range map[string]int{"USD": 2, "BTC": 8, "LTC": -1} {
+		if p := r.CurrencyPrecision(currency); p != expected {
+			t.Errorf("expected %s precision %d, got %d", currency, expected, p)
+		}
+	}
+
+	p, _ := r.Product("ETH-BTC")
+	if p.PricePrecision() != 5 {
+		t.Errorf("expected ETH-BTC price precision 5, got %d", p.PricePrecision())
+	}
+
+	for _, c := range [][3]string{
+		{"USD", "BTC", "BTC-USD"},
+		{"BTC", "ETH", "ETH-BTC"},
+		{"ETH", "USD", ""},
+	} {
+		if id := r.ProductIDForCurrencyPair(c[0], c[1]); id != c[2] {
+			t.Errorf("expected %s for %s and %s, got %q", c[2], c[0], c[1], id)
+		}
+	}
+}
+
+func TestProductCheckSize(t *testing.T) {
+	p, _ := makeRegistry(t).Product("BTC-USD")
+
+	for size, ok := range map[string]bool{
+		"0.001": false, "0.01": true, "100": true, "250.5": false,
+	} {
+		if err := p.CheckSize(d(size)); (err == nil) != ok {
+			t.Errorf("size %s: expected ok=%v, got %v", size, ok, err)
+		}
+	}
+}
+
+func TestRegistryJSON(t *testing.T) {
+	r := makeRegistry(t)
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var decoded Registry
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !reflect.DeepEqual(decoded.Products(), r.Products()) ||
+		!reflect.DeepEqual(decoded.Currencies(), r.Currencies()) {
+		t.Errorf("registry should survive a round trip through JSON")
+	}
+}
@@ -0,0 +1,81 @@
+package gdax
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	MessageRecord  = "message"
+	SnapshotRecord = "snapshot"
+	RegistryRecord = "registry"
+)
+
+// Record is a single entry in a recording. Data holds the raw JSON exactly as
+// it was received, a feed message for message records and a REST API order
+// book response for snapshot records. Registry records hold a marshaled
+// Registry.
+type Record struct {
+	Time      time.Time       `json:"time"`
+	Kind      string          `json:"kind"`
+	ProductID string          `json:"product_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Recorder appends feed messages and order book snapshots to a file, one JSON
+// encoded Record per line, so that order books can be rebuilt offline with a
+// Replay. It is safe for concurrent use.
+type Recorder struct {
+	*sync.Mutex
+
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewRecorder opens path for appending, creating it if needed
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		Mutex:   &sync.Mutex{},
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// RecordMessage appends a raw feed message
+func (r *Recorder) RecordMessage(raw []byte) error {
+	return r.write(Record{time.Now(), MessageRecord, "", raw})
+}
+
+// RecordSnapshot appends a raw order book snapshot for a product
+func (r *Recorder) RecordSnapshot(productID string, raw []byte) error {
+	return r.write(Record{time.Now(), SnapshotRecord, productID, raw})
+}
+
+// RecordRegistry appends the products and currencies listed by the exchange
+func (r *Recorder) RecordRegistry(registry *Registry) error {
+	raw, err := json.Marshal(registry)
+	if err != nil {
+		return err
+	}
+	return r.write(Record{time.Now(), RegistryRecord, "", raw})
+}
+
+func (r *Recorder) write(record Record) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.encoder.Encode(record)
+}
+
+// Close closes the underlying file
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.file.Close()
+}
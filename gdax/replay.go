@@ -0,0 +1,268 @@
+package gdax
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReplayOptions control how a recording is played back
+type ReplayOptions struct {
+	// RealTime paces playback by the time between recorded messages, otherwise
+	// messages are played back as fast as subscribers can take them
+	RealTime bool
+
+	// when StopProductID is set, playback stops after the first message for
+	// that product with a sequence number of at least StopSequence
+	StopProductID string
+	StopSequence  int64
+}
+
+// Replay plays back a recording made by a Recorder in place of a Feed. Order
+// books created with Replay.NewLiveOrderBook load the recorded snapshots, in
+// the order they were recorded, instead of fetching them from the REST API,
+// so they are rebuilt exactly as they were when recorded.
+type Replay struct {
+	*sync.Mutex
+
+	path    string
+	options ReplayOptions
+
+	registry      *Registry
+	snapshots     map[string][]json.RawMessage
+	subscribers   *fanOut
+	messageCounts map[string]int64
+	playing       bool
+
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// OpenReplay reads the snapshots from a recording and prepares it for
+// playback. Messages are streamed from the file once Play is called.
+func OpenReplay(path string, options ReplayOptions) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := &Replay{
+		Mutex:         &sync.Mutex{},
+		path:          path,
+		options:       options,
+		snapshots:     map[string][]json.RawMessage{},
+		subscribers:   newFanOut(),
+		messageCounts: map[string]int64{},
+		done:          make(chan struct{}),
+		finished:      make(chan struct{}),
+	}
+
+	d := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record Record
+		if err := d.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Error reading recording %s: %s", path, err)
+		}
+
+		switch record.Kind {
+		case SnapshotRecord:
+			r.snapshots[record.ProductID] =
+				append(r.snapshots[record.ProductID], record.Data)
+		case RegistryRecord:
+			var registry Registry
+			if err := json.Unmarshal(record.Data, &registry); err != nil {
+				return nil, fmt.Errorf("Error reading registry from %s: %s", path, err)
+			}
+			r.registry = &registry
+		}
+	}
+
+	return r, nil
+}
+
+// Registry returns the last products and currencies in the recording, or an
+// error if none were recorded
+func (r *Replay) Registry() (*Registry, error) {
+	if r.registry == nil {
+		return nil, fmt.Errorf("no products or currencies recorded in %s", r.path)
+	}
+	return r.registry, nil
+}
+
+// ProductIDs returns the products that snapshots were recorded for, ordered
+func (r *Replay) ProductIDs() []string {
+	r.Lock()
+	defer r.Unlock()
+	productIDs := make([]string, 0, len(r.snapshots))
+	for productID := range r.snapshots {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Strings(productIDs)
+	return productIDs
+}
+
+// NewLiveOrderBook creates an order book that is driven by the replay. level
+// should match the level the book was recorded at.
+func (r *Replay) NewLiveOrderBook(
+	productID string, level int, done <-chan struct{},
+) (*LiveOrderBook, error) {
+	r.Lock()
+	_, ok := r.snapshots[productID]
+	r.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no recorded snapshots for %s", productID)
+	}
+
+	loadSnapshot := func() (*OrderBook, error) {
+		body, err := r.nextSnapshot(productID)
+		if err != nil {
+			return nil, err
+		}
+
+		ob := OrderBook{}
+		if err := json.Unmarshal(body, &ob); err != nil {
+			return nil, err
+		}
+		return &ob, nil
+	}
+
+	return newLiveOrderBook(r, loadSnapshot, productID, level, done), nil
+}
+
+func (r *Replay) nextSnapshot(productID string) (json.RawMessage, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	snapshots := r.snapshots[productID]
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no more recorded snapshots for %s", productID)
+	}
+	r.snapshots[productID] = snapshots[1:]
+	return snapshots[0], nil
+}
+
+// Subscribe sends the messages played back that match filter to c. Playback
+// keeps pace with the slowest subscriber, so that nothing recorded is dropped.
+func (r *Replay) Subscribe(c chan Message, filter Filter) *Subscription {
+	return r.subscribers.subscribe(c, filter, 0, BlockOnOverflow)
+}
+
+// Play starts playing back recorded messages to subscribers. Order books
+// should be created before calling Play so that they see every message.
+func (r *Replay) Play() {
+	r.Lock()
+	r.playing = true
+	r.Unlock()
+
+	go r.play()
+}
+
+// Finished returns a channel that is closed when playback ends
+func (r *Replay) Finished() <-chan struct{} {
+	return r.finished
+}
+
+// Connected reports whether the replay is still playing
+func (r *Replay) Connected() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.playing
+}
+
+// MessageCounts returns the number of messages played back so far, by
+// message type
+func (r *Replay) MessageCounts() map[string]int64 {
+	r.Lock()
+	defer r.Unlock()
+	counts := make(map[string]int64, len(r.messageCounts))
+	for t, n := range r.messageCounts {
+		counts[t] = n
+	}
+	return counts
+}
+
+// Close stops playback and closes all subscriber channels
+func (r *Replay) Close() {
+	r.Lock()
+	defer r.Unlock()
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
+
+func (r *Replay) play() {
+	defer func() {
+		r.Lock()
+		r.playing = false
+		r.Unlock()
+		r.subscribers.end()
+		close(r.finished)
+	}()
+
+	file, err := os.Open(r.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening recording: %s\n", err)
+		return
+	}
+	defer file.Close()
+
+	var last time.Time
+	d := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record Record
+		if err := d.Decode(&record); err == io.EOF {
+			return
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading recording: %s\n", err)
+			return
+		}
+
+		if record.Kind != MessageRecord {
+			continue
+		}
+
+		message, err := ParseMessage(record.Data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decoding recorded message: %s\n", err)
+			continue
+		}
+		h := message.Header()
+
+		if r.options.RealTime && !last.IsZero() {
+			select {
+			case <-time.After(record.Time.Sub(last)):
+			case <-r.done:
+				return
+			}
+		}
+		last = record.Time
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		r.Lock()
+		r.messageCounts[h.Type]++
+		r.Unlock()
+
+		r.subscribers.deliver(message)
+
+		if len(r.options.StopProductID) > 0 &&
+			h.ProductID == r.options.StopProductID &&
+			h.Sequence >= r.options.StopSequence {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package gdax
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRecording(t *testing.T, snapshot string, messages []string) string {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer r.Close()
+
+	// the first message arrives before the snapshot is loaded, as it would live
+	r.RecordMessage([]byte(messages[0]))
+	r.RecordSnapshot("LTC-USD", []byte(snapshot))
+	for _, m := range messages[1:] {
+		r.RecordMessage([]byte(m))
+	}
+	return path
+}
+
+func waitForSequence(t *testing.T, lob *LiveOrderBook, sequence int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := lob.Status(); s.Running && s.Sequence == sequence {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for sequence %d, status %+v", sequence, lob.Status())
+}
+
+var replayMessages = []string{
+	`{"type":"open","sequence":11,"product_id":"LTC-USD","order_id":"order-x",
+		"side":"buy","price":"49.99","remaining_size":"3"}`,
+	`{"type":"match","sequence":12,"product_id":"LTC-USD",
+		"maker_order_id":"order-e","price":"50.01","size":"1.5"}`,
+	`{"type":"done","sequence":13,"product_id":"LTC-USD","order_id":"order-a"}`,
+}
+
+const replaySnapshot = `{"sequence":10,
+	"bids":[["49.97","11.5","order-a"],["49.96","9.5","order-b"]],
+	"asks":[["50.01","4.5","order-e"],["50.06","6.5","order-f"]]}`
+
+func TestReplay(t *testing.T) {
+	path := writeRecording(t, replaySnapshot, replayMessages)
+
+	replay, err := OpenReplay(path, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+
+	lob, err := replay.NewLiveOrderBook("LTC-USD", 3, done)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	replay.Play()
+	<-replay.Finished()
+	waitForSequence(t, lob, 13)
+
+	lob.RLock()
+	defer lob.RUnlock()
+	if lob.Bids.Len() != 2 || lob.Bids.Best().OrderID != "order-x" {
+		t.Errorf("replayed open and done weren't applied to the bids")
+	}
+	if e := lob.Find("order-e"); e == nil || e.Size.Cmp(d("3")) != 0 {
+		t.Errorf("replayed match wasn't applied to order-e")
+	}
+}
+
+func TestReplayStop(t *testing.T) {
+	path := writeRecording(t, replaySnapshot, replayMessages)
+
+	replay, err := OpenReplay(path, ReplayOptions{
+		StopProductID: "LTC-USD", StopSequence: 12,
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+
+	lob, _ := replay.NewLiveOrderBook("LTC-USD", 3, done)
+	replay.Play()
+	<-replay.Finished()
+	waitForSequence(t, lob, 12)
+
+	if lob.Find("order-a") == nil {
+		t.Errorf("playback should stop before order-a is done")
+	}
+}
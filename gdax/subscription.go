@@ -0,0 +1,349 @@
+package gdax
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultBufferSize is how many messages a subscriber can fall behind by
+// before its overflow policy applies, unless SetOverflowPolicy says otherwise
+const DefaultBufferSize = 1024
+
+// OverflowPolicy is what a feed does with a message for a subscriber whose
+// buffer is full
+type OverflowPolicy string
+
+const (
+	// DropOnOverflow drops the message and, once there's room again, sends
+	// the subscriber a Gap message so it knows messages were missed
+	DropOnOverflow OverflowPolicy = "drop"
+
+	// DisconnectOnOverflow closes the subscriber's channel, after it has
+	// received what's already buffered, and sends it nothing more
+	DisconnectOnOverflow OverflowPolicy = "disconnect"
+
+	// BlockOnOverflow waits for the subscriber to make room, holding up every
+	// other subscriber meanwhile
+	BlockOnOverflow OverflowPolicy = "block"
+)
+
+// ParseOverflowPolicy returns the policy named s
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case DropOnOverflow, DisconnectOnOverflow, BlockOnOverflow:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q, expected drop, disconnect or block", s)
+}
+
+// Filter selects the messages a subscriber receives: those for one of
+// ProductIDs, of one of Types. Empty fields match everything. Messages that
+// aren't for any product, such as Reconnected, are sent to every
+// subscriber whose Types match them.
+type Filter struct {
+	ProductIDs []string
+	Types      []string
+}
+
+// Subscription is a subscriber's place in a feed's fan-out. Messages for the
+// subscriber are queued in a buffer of their own and passed on to its channel
+// as fast as it takes them, so a slow subscriber only holds up itself
+type Subscription struct {
+	*sync.Mutex
+
+	c      chan Message
+	buffer chan Message
+	policy OverflowPolicy
+	filter Filter
+	types  map[string]bool
+
+	// removes the subscription from its source's fan-out
+	unsubscribe func()
+
+	// ended is closed once nothing more will be buffered, cancelled when the
+	// subscriber doesn't want what's left either
+	ended, cancelled chan struct{}
+	endOnce          *sync.Once
+	cancelOnce       *sync.Once
+
+	// only touched by the goroutine delivering messages
+	gap bool
+
+	dropped      int64
+	disconnected bool
+}
+
+// SubscriptionStats describe how far behind a subscriber is. Lag is the number
+// of messages buffered for it, Dropped the number it has missed
+type SubscriptionStats struct {
+	Lag          int
+	BufferSize   int
+	Dropped      int64
+	Disconnected bool
+}
+
+func newSubscription(c chan Message, filter Filter, size int, policy OverflowPolicy) *Subscription {
+	s := &Subscription{
+		Mutex:       &sync.Mutex{},
+		c:           c,
+		buffer:      make(chan Message, size),
+		policy:      policy,
+		filter:      filter,
+		unsubscribe: func() {},
+		ended:       make(chan struct{}),
+		cancelled:   make(chan struct{}),
+		endOnce:     &sync.Once{},
+		cancelOnce:  &sync.Once{},
+	}
+	if len(filter.Types) > 0 {
+		s.types = map[string]bool{}
+		for _, t := range filter.Types {
+			s.types[t] = true
+		}
+	}
+	go s.forward()
+	return s
+}
+
+// forward passes buffered messages on to the subscriber. its channel is closed
+// once the subscription has ended and the buffer is drained, or as soon as the
+// subscription is cancelled
+func (s *Subscription) forward() {
+	defer close(s.c)
+	for {
+		select {
+		case m := <-s.buffer:
+			if !s.send(m) {
+				return
+			}
+		case <-s.ended:
+			for {
+				select {
+				case m := <-s.buffer:
+					if !s.send(m) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-s.cancelled:
+			return
+		}
+	}
+}
+
+func (s *Subscription) send(m Message) bool {
+	select {
+	case s.c <- m:
+		return true
+	case <-s.cancelled:
+		return false
+	}
+}
+
+// Unsubscribe stops sending messages to the subscriber and closes its channel,
+// dropping anything still buffered for it
+func (s *Subscription) Unsubscribe() {
+	s.cancelOnce.Do(func() {
+		s.unsubscribe()
+		close(s.cancelled)
+	})
+}
+
+// Stats returns how far behind the subscriber is
+func (s *Subscription) Stats() SubscriptionStats {
+	s.Lock()
+	defer s.Unlock()
+	return SubscriptionStats{
+		Lag:          len(s.buffer),
+		BufferSize:   cap(s.buffer),
+		Dropped:      s.dropped,
+		Disconnected: s.disconnected,
+	}
+}
+
+// wants reports whether the subscriber's filter matches the type of m. its
+// product is matched by the fan-out
+func (s *Subscription) wants(m Message) bool {
+	return s.types == nil || s.types[m.Header().Type]
+}
+
+// deliver buffers m for the subscriber, applying the overflow policy when the
+// buffer is full, and reports whether the subscriber is still connected. It
+// must only be called from one goroutine at a time
+func (s *Subscription) deliver(m Message) bool {
+	select {
+	case <-s.cancelled:
+		return false
+	default:
+	}
+
+	// nothing is queued after a gap until the subscriber's been told about it
+	if s.gap {
+		select {
+		case s.buffer <- Gap{MessageHeader{Type: GapMessage}}:
+			s.gap = false
+		default:
+		}
+	}
+	if !s.gap {
+		select {
+		case s.buffer <- m:
+			return true
+		default:
+		}
+	}
+
+	switch s.policy {
+	case BlockOnOverflow:
+		select {
+		case s.buffer <- m:
+			return true
+		case <-s.cancelled:
+			return false
+		}
+
+	case DisconnectOnOverflow:
+		s.Lock()
+		s.dropped++
+		s.disconnected = true
+		s.Unlock()
+		s.end()
+		return false
+
+	default:
+		s.Lock()
+		s.dropped++
+		s.Unlock()
+		s.gap = true
+		return true
+	}
+}
+
+// end closes the subscriber's channel once it has received everything
+// buffered for it
+func (s *Subscription) end() {
+	s.endOnce.Do(func() { close(s.ended) })
+}
+
+// fanOut routes messages to the subscriptions whose filters match them. They
+// are indexed by product, so routing a message only involves the subscribers
+// to its product and those to every product
+type fanOut struct {
+	*sync.Mutex
+
+	byProduct   map[string][]*Subscription
+	allProducts []*Subscription
+}
+
+func newFanOut() *fanOut {
+	return &fanOut{
+		Mutex:     &sync.Mutex{},
+		byProduct: map[string][]*Subscription{},
+	}
+}
+
+// subscribe adds a subscription that sends messages matching filter to c
+func (o *fanOut) subscribe(
+	c chan Message, filter Filter, size int, policy OverflowPolicy,
+) *Subscription {
+	s := newSubscription(c, filter, size, policy)
+	s.unsubscribe = func() { o.remove(s) }
+
+	o.Lock()
+	defer o.Unlock()
+	if len(filter.ProductIDs) == 0 {
+		o.allProducts = append(o.allProducts, s)
+	}
+	seen := map[string]bool{}
+	for _, productID := range filter.ProductIDs {
+		if !seen[productID] {
+			seen[productID] = true
+			o.byProduct[productID] = append(o.byProduct[productID], s)
+		}
+	}
+	return s
+}
+
+func (o *fanOut) remove(s *Subscription) {
+	without := func(subscriptions []*Subscription) []*Subscription {
+		kept := make([]*Subscription, 0, len(subscriptions))
+		for _, other := range subscriptions {
+			if other != s {
+				kept = append(kept, other)
+			}
+		}
+		return kept
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	o.allProducts = without(o.allProducts)
+	for _, productID := range s.filter.ProductIDs {
+		if kept := without(o.byProduct[productID]); len(kept) > 0 {
+			o.byProduct[productID] = kept
+		} else {
+			delete(o.byProduct, productID)
+		}
+	}
+}
+
+// route returns the subscriptions m should be sent to
+func (o *fanOut) route(m Message) []*Subscription {
+	o.Lock()
+	defer o.Unlock()
+
+	productID := m.Header().ProductID
+	var candidates []*Subscription
+	if len(productID) == 0 {
+		seen := map[*Subscription]bool{}
+		candidates = append(candidates, o.allProducts...)
+		for _, subscriptions := range o.byProduct {
+			for _, s := range subscriptions {
+				if !seen[s] {
+					seen[s] = true
+					candidates = append(candidates, s)
+				}
+			}
+		}
+	} else {
+		candidates = append(candidates, o.allProducts...)
+		candidates = append(candidates, o.byProduct[productID]...)
+	}
+
+	routed := candidates[:0]
+	for _, s := range candidates {
+		if s.wants(m) {
+			routed = append(routed, s)
+		}
+	}
+	return routed
+}
+
+// deliver buffers m for every subscription it's routed to. the lock isn't held
+// while delivering, so that a subscriber blocking the feed doesn't block
+// subscribing or unsubscribing. subscribers disconnected for falling behind
+// are removed
+func (o *fanOut) deliver(m Message) {
+	for _, s := range o.route(m) {
+		if !s.deliver(m) {
+			o.remove(s)
+		}
+	}
+}
+
+// end ends every subscription, closing their channels once they've received
+// everything buffered for them
+func (o *fanOut) end() {
+	o.Lock()
+	defer o.Unlock()
+	for _, s := range o.allProducts {
+		s.end()
+	}
+	for _, subscriptions := range o.byProduct {
+		for _, s := range subscriptions {
+			s.end()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package gdax
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// receive reads a message from c, failing the test if none arrives in time
+func receive(t *testing.T, c <-chan Message) (Message, bool) {
+	t.Helper()
+	select {
+	case m, ok := <-c:
+		return m, ok
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a message")
+		return nil, false
+	}
+}
+
+func TestSlowSubscriberDoesntBlockFeed(t *testing.T) {
+	f := &Feed{Mutex: &sync.Mutex{}, subscribers: newFanOut(), bufferSize: 2, overflow: DropOnOverflow}
+	slow, fast := make(chan Message), make(chan Message, 10)
+	s := f.Subscribe(slow, Filter{})
+	f.SetOverflowPolicy(10, DropOnOverflow)
+	f.Subscribe(fast, Filter{})
+
+	// wait for the first message to be taken by the goroutine forwarding them,
+	// which then waits on the slow subscriber
+	f.subscribers.deliver(MessageHeader{Type: OpenMessage, Sequence: 1})
+	for s.Stats().Lag > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := int64(2); i <= 5; i++ {
+		f.subscribers.deliver(MessageHeader{Type: OpenMessage, Sequence: i})
+	}
+	for i := int64(1); i <= 5; i++ {
+		if m, _ := receive(t, fast); m.Header().Sequence != i {
+			t.Errorf("expected message %d, got %d", i, m.Header().Sequence)
+		}
+	}
+
+	// one message is held by the goroutine forwarding them and two are
+	// buffered, the rest were dropped
+	if stats := s.Stats(); stats.Lag != 2 || stats.Dropped != 2 {
+		t.Errorf("expected a lag of 2 and 2 dropped, got %+v", stats)
+	}
+
+	for _, expected := range []int64{1, 2, 3} {
+		if m, _ := receive(t, slow); m.Header().Sequence != expected {
+			t.Errorf("expected message %d, got %d", expected, m.Header().Sequence)
+		}
+	}
+
+	// the gap is flagged before anything else is sent
+	f.subscribers.deliver(MessageHeader{Type: OpenMessage, Sequence: 6})
+	for _, expected := range []string{GapMessage, OpenMessage} {
+		if m, _ := receive(t, slow); m.Header().Type != expected {
+			t.Errorf("expected a %s message, got %s", expected, m.Header().Type)
+		}
+	}
+}
+
+func TestDisconnectOnOverflow(t *testing.T) {
+	f := &Feed{Mutex: &sync.Mutex{}, subscribers: newFanOut(), bufferSize: 1, overflow: DisconnectOnOverflow}
+	c := make(chan Message)
+	s := f.Subscribe(c, Filter{})
+
+	for i := int64(1); i <= 3; i++ {
+		f.subscribers.deliver(MessageHeader{Type: OpenMessage, Sequence: i})
+	}
+	if !s.Stats().Disconnected {
+		t.Errorf("the subscriber should be disconnected")
+	}
+
+	// what was buffered is still delivered before the channel is closed
+	for {
+		if _, ok := receive(t, c); !ok {
+			break
+		}
+	}
+	if len(f.subscribers.route(MessageHeader{Type: OpenMessage})) != 0 {
+		t.Errorf("the subscriber should be removed from the feed")
+	}
+}
+
+func TestFilteredSubscriptions(t *testing.T) {
+	o := newFanOut()
+	btc, matches, all := make(chan Message, 10), make(chan Message, 10), make(chan Message, 10)
+	btcSubscription := o.subscribe(btc, Filter{ProductIDs: []string{"BTC-USD"}}, 10, DropOnOverflow)
+	o.subscribe(matches, Filter{Types: []string{MatchMessage, ReconnectedMessage}}, 10, DropOnOverflow)
+	o.subscribe(all, Filter{}, 10, DropOnOverflow)
+
+	for _, m := range []MessageHeader{
+		{Type: OpenMessage, ProductID: "BTC-USD", Sequence: 1},
+		{Type: MatchMessage, ProductID: "ETH-USD", Sequence: 2},
+		{Type: ReconnectedMessage, Sequence: 3},
+	} {
+		o.deliver(m)
+	}
+
+	for _, c := range []struct {
+		name      string
+		c         chan Message
+		sequences []int64
+	}{
+		{"BTC-USD", btc, []int64{1, 3}},
+		{"matches", matches, []int64{2, 3}},
+		{"all", all, []int64{1, 2, 3}},
+	} {
+		for _, expected := range c.sequences {
+			if m, _ := receive(t, c.c); m.Header().Sequence != expected {
+				t.Errorf("%s: expected message %d, got %d", c.name, expected, m.Header().Sequence)
+			}
+		}
+	}
+
+	btcSubscription.Unsubscribe()
+	if _, ok := receive(t, btc); ok {
+		t.Errorf("unsubscribing should close the channel")
+	}
+	if routed := o.route(MessageHeader{Type: OpenMessage, ProductID: "BTC-USD"}); len(routed) != 1 {
+		t.Errorf("expected only the subscriber to every product, got %d", len(routed))
+	}
+}
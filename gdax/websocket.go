@@ -2,165 +2,572 @@ package gdax
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/websocket"
 )
 
 const (
-	MaxSubscribers = 32
+	MinReconnectBackoff = time.Second
+	MaxReconnectBackoff = time.Minute
+
+	// the path feed subscriptions are signed for, as if it were a REST request
+	feedAuthPath = "/users/self/verify"
 )
 
+// how long NewFeed waits for GDAX to confirm its subscriptions. a variable so
+// tests can shorten it
+var subscribeTimeout = 10 * time.Second
+
+// ErrSubscribeTimeout is returned by NewFeed when GDAX doesn't answer its
+// subscriptions in time
+var ErrSubscribeTimeout = errors.New("timed out waiting for the feed to confirm subscriptions")
+
+// SubscriptionError is returned by NewFeed when GDAX confirms its
+// subscriptions without some of the products asked for. Missing lists them by
+// channel.
+type SubscriptionError struct {
+	Missing map[string][]string
+}
+
+func (e *SubscriptionError) Error() string {
+	channels := make([]string, 0, len(e.Missing))
+	for channel := range e.Missing {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	missing := make([]string, len(channels))
+	for i, channel := range channels {
+		missing[i] = fmt.Sprintf("%s on the %s channel",
+			strings.Join(e.Missing[channel], ", "), channel)
+	}
+	return fmt.Sprintf("subscriptions weren't confirmed for %s", strings.Join(missing, "; "))
+}
+
+// Feed is a connection to the GDAX websocket feed. If the connection drops or
+// the feed sends something that isn't JSON, Feed sends subscribers a
+// Disconnected message straight away, then reconnects with exponential
+// backoff, resubscribes to its products, and sends them a Reconnected message
+// so they know messages may have been missed. Messages that are JSON
+// but can't be parsed are reported, with the raw message, and skipped.
+//
+// Error messages from GDAX are sent to subscribers like any other. Error is
+// an error type, so subscribers can pass them on as they are.
+//
+// Each subscriber has a buffer of its own, so one that falls behind doesn't
+// hold up the others until its buffer fills. What happens then is up to the
+// feed's OverflowPolicy, DropOnOverflow unless SetOverflowPolicy changes it.
 type Feed struct {
 	*sync.Mutex
-	*websocket.Conn
 
-	done        chan struct{}
-	subscribers []chan Message
-	parserStack []rune
+	url        string
+	origin     string
+	productIDs []string
+	channels   []Channel
+
+	// signs subscriptions when set
+	credentials *Credentials
+
+	// set while NewFeed waits for its subscriptions to be answered
+	confirmation chan Message
+
+	conn          *websocket.Conn
+	done          chan struct{}
+	subscribers   *fanOut
+	bufferSize    int
+	overflow      OverflowPolicy
+	messageCounts map[string]int64
+	recorder      *Recorder
+	parserStack   []rune
+}
+
+// Channel names a websocket channel and the products to receive it for. With
+// no ProductIDs it applies to the feed's products.
+type Channel struct {
+	Name       string   `json:"name"`
+	ProductIDs []string `json:"product_ids,omitempty"`
 }
 
 const (
-	ReceivedMessage            = "received"
-	OpenMessage                = "open"
-	DoneMessage                = "done"
-	MatchMessage               = "match"
-	ChangeMessage              = "change"
-	MarginProfileUpdateMessage = "margin_profile_update"
-	HeartbeatMessage           = "heartbeat"
-	ErrorMessage               = "error"
+	FullChannel   = "full"
+	Level2Channel = "level2"
 )
 
-type Message struct {
-	Type               string `json:"type"`
-	Time               string `json:"time,omitempty"`
-	Sequence           int64  `json:"sequence,omitempty"`
-	ProductID          string `json:"product_id,omitempty"`
-	OrderID            string `json:"order_id,omitempty"`
-	Size               string `json:"size,omitempty"`
-	Price              string `json:"price,omitempty"`
-	Side               string `json:"side,omitempty"`
-	OrderType          string `json:"order_type,omitempty"`
-	Funds              string `json:"funds,omitempty"`
-	Reason             string `json:"reason,omitempty"`
-	RemainingSize      string `json:"remaining_size,omitempty"`
-	TradeID            int64  `json:"trade_id,omitempty"`
-	MakerOrderID       string `json:"maker_order_id,omitempty"`
-	TakerOrderID       string `json:"taker_order_id,omitempty"`
-	TakerUserID        string `json:"taker_user_id,omitempty"`
-	UserID             string `json:"user_id,omitempty"`
-	TakerProfileID     string `json:"taker_profile_id,omitempty"`
-	ProfileID          string `json:"profile_id,omitempty"`
-	NewSize            string `json:"new_size,omitempty"`
-	OldSize            string `json:"old_size,omitempty"`
-	NewFunds           string `json:"new_funds,omitempty"`
-	OldFunds           string `json:"old_funds,omitempty"`
-	Timestamp          string `json:"timestamp,omitempty"`
-	Nonce              int64  `json:"nonce,omitempty"`
-	Position           string `json:"position,omitempty"`
-	PositionSize       string `json:"position_size,omitempty"`
-	PositionCompliment string `json:"position_compliment,omitempty"`
-	PositionMaxSize    string `json:"position_max_size,omitempty"`
-	CallSide           string `json:"call_side,omitempty"`
-	CallPrice          string `json:"call_price,omitempty"`
-	CallSize           string `json:"call_size,omitempty"`
-	CallFunds          string `json:"call_funds,omitempty"`
-	Covered            bool   `json:"covered,omitempty"`
-	NextExpireTime     string `json:"next_expire_time,omitempty"`
-	BaseBalance        string `json:"base_balance,omitempty"`
-	BaseFunding        string `json:"base_funding,omitempty"`
-	QuoteBalance       string `json:"quote_balance,omitempty"`
-	QuoteFunding       string `json:"quote_funding,omitempty"`
-	Private            bool   `json:"private,omitempty"`
-	LastTradeID        int64  `json:"last_trade_id,omitempty"`
-	Message            string `json:"message,omitempty"`
-}
-
-func NewFeed(url, origin string, productIDs []string) (*Feed, error) {
-	conn, err := websocket.Dial(url, "", origin)
+// NewFeed connects to the feed and subscribes to productIDs. When no channels
+// are given every product gets the full channel, which level 3 order books are
+// built from. Products can be added and removed later with AddProduct and
+// RemoveProduct.
+//
+// NewFeed returns once GDAX has confirmed the subscriptions. If it answers
+// with an error that's returned, as an Error, and if it leaves any products
+// out a *SubscriptionError is. ErrSubscribeTimeout is returned if it doesn't
+// answer at all.
+func NewFeed(url, origin string, productIDs []string, channels ...Channel) (*Feed, error) {
+	return NewAuthenticatedFeed(url, origin, nil, productIDs, channels...)
+}
+
+// NewAuthenticatedFeed is NewFeed with subscriptions signed by credentials, so
+// that messages about our own orders carry our UserID and ProfileID. When
+// credentials is nil the subscriptions aren't signed.
+func NewAuthenticatedFeed(
+	url, origin string, credentials *Credentials, productIDs []string, channels ...Channel,
+) (*Feed, error) {
+	f := &Feed{
+		Mutex:         &sync.Mutex{},
+		url:           url,
+		origin:        origin,
+		productIDs:    productIDs,
+		channels:      channels,
+		credentials:   credentials,
+		done:          make(chan struct{}),
+		subscribers:   newFanOut(),
+		bufferSize:    DefaultBufferSize,
+		overflow:      DropOnOverflow,
+		messageCounts: map[string]int64{},
+	}
+
+	confirmation := make(chan Message, 1)
+	f.confirmation = confirmation
+
+	conn, err := f.dial()
 	if err != nil {
 		return nil, err
 	}
+	f.conn = conn
 
-	f := &Feed{
-		Mutex:       &sync.Mutex{},
-		Conn:        conn,
-		done:        make(chan struct{}),
-		subscribers: make([]chan Message, MaxSubscribers),
+	go f.listen(conn)
+
+	if err := f.awaitConfirmation(confirmation); err != nil {
+		f.Close()
+		return nil, err
 	}
 
-	go f.listen()
+	return f, nil
+}
 
-	marshaled, err := json.Marshal(struct {
-		Type       string   `json:"type"`
-		ProductIDs []string `json:"product_ids"`
-		Signature  string   `json:"signature,omitempty"`
-		Key        string   `json:"key,omitempty"`
-		Passphrase string   `json:"passphrase,omitempty"`
-		Timestamp  string   `json:"timestamp,omitempty"`
-	}{
-		Type:       "subscribe",
-		ProductIDs: productIDs,
-	})
+// awaitConfirmation waits for the answer to the feed's first subscriptions,
+// and checks that every product on every channel was subscribed to
+func (f *Feed) awaitConfirmation(confirmation <-chan Message) error {
+	var m Message
+	select {
+	case m = <-confirmation:
+	case <-time.After(subscribeTimeout):
+		return ErrSubscribeTimeout
+	}
+
+	subscriptions, ok := m.(Subscriptions)
+	if !ok {
+		return m.(Error)
+	}
+
+	confirmed := map[string]map[string]bool{}
+	for _, c := range subscriptions.Channels {
+		confirmed[c.Name] = map[string]bool{}
+		for _, productID := range c.ProductIDs {
+			confirmed[c.Name][productID] = true
+		}
+	}
+
+	f.Lock()
+	requested := explicitChannels(f.productIDs, f.channels)
+	f.Unlock()
+
+	missing := map[string][]string{}
+	for _, c := range requested {
+		for _, productID := range c.ProductIDs {
+			if !confirmed[c.Name][productID] {
+				missing[c.Name] = append(missing[c.Name], productID)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return &SubscriptionError{missing}
+	}
+	return nil
+}
+
+// confirm passes the answer to the feed's first subscriptions, a
+// Subscriptions or Error message, to NewFeed
+func (f *Feed) confirm(m Message) {
+	f.Lock()
+	defer f.Unlock()
+	if f.confirmation != nil {
+		f.confirmation <- m
+		f.confirmation = nil
+	}
+}
+
+// SetOverflowPolicy sets the buffer size and overflow policy of subscribers
+// added from now on
+func (f *Feed) SetOverflowPolicy(bufferSize int, policy OverflowPolicy) {
+	f.Lock()
+	defer f.Unlock()
+	f.bufferSize = bufferSize
+	f.overflow = policy
+}
+
+// Subscribe sends the messages from the feed that match filter to c, buffered
+// according to the feed's overflow policy. c is closed when the feed is, when
+// the subscriber is disconnected for falling behind, or when it unsubscribes.
+func (f *Feed) Subscribe(c chan Message, filter Filter) *Subscription {
+	f.Lock()
+	size, policy := f.bufferSize, f.overflow
+	f.Unlock()
+	return f.subscribers.subscribe(c, filter, size, policy)
+}
+
+// Close disconnects from the feed and closes all subscriber channels
+func (f *Feed) Close() {
+	f.Lock()
+	if f.isClosed() {
+		f.Unlock()
+		return
+	}
+	close(f.done)
+	conn := f.conn
+	f.conn = nil
+	f.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Record starts appending every message received from the feed to r. Order
+// books created from the feed with API.NewLiveOrderBook also record the
+// snapshots they load to r.
+func (f *Feed) Record(r *Recorder) {
+	f.Lock()
+	f.recorder = r
+	f.Unlock()
+}
+
+func (f *Feed) getRecorder() *Recorder {
+	f.Lock()
+	defer f.Unlock()
+	return f.recorder
+}
+
+// MessageCounts returns the number of messages received from GDAX since the
+// feed was created, by message type
+func (f *Feed) MessageCounts() map[string]int64 {
+	f.Lock()
+	defer f.Unlock()
+	counts := make(map[string]int64, len(f.messageCounts))
+	for t, n := range f.messageCounts {
+		counts[t] = n
+	}
+	return counts
+}
+
+// Connected reports whether the feed currently has a live connection
+func (f *Feed) Connected() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.conn != nil
+}
+
+func (f *Feed) isClosed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dial connects to the feed and subscribes to the feed's products
+func (f *Feed) dial() (*websocket.Conn, error) {
+	conn, err := websocket.Dial(f.url, "", f.origin)
 	if err != nil {
-		f.done <- struct{}{}
 		return nil, err
 	}
 
-	_, err = f.Write(marshaled)
+	f.Lock()
+	subscribe, err := f.subscription("subscribe", f.productIDs, f.channels)
+	f.Unlock()
 	if err != nil {
-		f.done <- struct{}{}
+		conn.Close()
 		return nil, err
 	}
 
-	return f, nil
+	if _, err = conn.Write(subscribe); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// subscription builds a subscribe or unsubscribe message, signed when the feed
+// has credentials
+func (f *Feed) subscription(t string, productIDs []string, channels []Channel) ([]byte, error) {
+	message := struct {
+		Type       string    `json:"type"`
+		ProductIDs []string  `json:"product_ids"`
+		Channels   []Channel `json:"channels,omitempty"`
+		Signature  string    `json:"signature,omitempty"`
+		Key        string    `json:"key,omitempty"`
+		Passphrase string    `json:"passphrase,omitempty"`
+		Timestamp  string    `json:"timestamp,omitempty"`
+	}{
+		Type:       t,
+		ProductIDs: productIDs,
+		Channels:   channels,
+	}
+
+	// signed afresh every time, GDAX rejects old timestamps
+	if f.credentials != nil {
+		var err error
+		message.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		message.Signature, err = f.credentials.Sign(
+			message.Timestamp, "GET", feedAuthPath, "")
+		if err != nil {
+			return nil, err
+		}
+		message.Key = f.credentials.Key
+		message.Passphrase = f.credentials.Passphrase
+	}
+
+	return json.Marshal(message)
 }
 
-func (f *Feed) Subscribe(c chan Message) {
+// AddProduct subscribes the running feed to productID on channel, FullChannel
+// when it's empty. The product is resubscribed to after reconnecting, like
+// those the feed was created with.
+func (f *Feed) AddProduct(productID, channel string) error {
+	if len(channel) == 0 {
+		channel = FullChannel
+	}
+
 	f.Lock()
-	f.subscribers = append(f.subscribers, c)
+	if f.isClosed() {
+		f.Unlock()
+		return fmt.Errorf("the feed is closed")
+	}
+	for _, p := range f.productIDs {
+		if p == productID {
+			f.Unlock()
+			return fmt.Errorf("%s is already subscribed to", productID)
+		}
+	}
+
+	// the product only gets the full channel by default when no channels are
+	// given, so once another is used every channel's products are listed
+	if len(f.channels) > 0 || channel != FullChannel {
+		channels := explicitChannels(f.productIDs, f.channels)
+		found := false
+		for i := range channels {
+			if channels[i].Name == channel {
+				channels[i].ProductIDs = append(channels[i].ProductIDs, productID)
+				found = true
+			}
+		}
+		if !found {
+			channels = append(channels, Channel{Name: channel, ProductIDs: []string{productID}})
+		}
+		f.channels = channels
+	}
+	f.productIDs = append(append([]string{}, f.productIDs...), productID)
+
+	message, err := f.subscription("subscribe", []string{productID}, []Channel{{Name: channel}})
+	conn := f.conn
 	f.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f.send(conn, message)
+	return nil
 }
 
-func (f *Feed) Close() {
-	f.Conn.Close()
-	f.Conn = nil
-	f.done <- struct{}{}
+// RemoveProduct unsubscribes the running feed from productID on every channel.
+// Subscribers to it stay subscribed, but are sent nothing more for it.
+func (f *Feed) RemoveProduct(productID string) error {
+	f.Lock()
+	if f.isClosed() {
+		f.Unlock()
+		return fmt.Errorf("the feed is closed")
+	}
+
+	var productIDs []string
+	for _, p := range f.productIDs {
+		if p != productID {
+			productIDs = append(productIDs, p)
+		}
+	}
+	if len(productIDs) == len(f.productIDs) {
+		f.Unlock()
+		return fmt.Errorf("%s isn't subscribed to", productID)
+	}
+
+	unsubscribed := []Channel{{Name: FullChannel}}
+	if len(f.channels) > 0 {
+		unsubscribed = nil
+		var channels []Channel
+		for _, c := range explicitChannels(f.productIDs, f.channels) {
+			var kept []string
+			for _, p := range c.ProductIDs {
+				if p != productID {
+					kept = append(kept, p)
+				}
+			}
+			if len(kept) < len(c.ProductIDs) {
+				unsubscribed = append(unsubscribed, Channel{Name: c.Name})
+			}
+			if len(kept) > 0 {
+				channels = append(channels, Channel{Name: c.Name, ProductIDs: kept})
+			}
+		}
+		f.channels = channels
+	}
+	f.productIDs = productIDs
+
+	message, err := f.subscription("unsubscribe", []string{productID}, unsubscribed)
+	conn := f.conn
+	f.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f.send(conn, message)
+	return nil
 }
 
-func (f *Feed) listen() {
-	d := json.NewDecoder(f)
-loop:
+// send writes a subscription change to the connection, if there is one. one
+// that can't be written is closed, so that the feed reconnects and subscribes
+// to its products as they are now
+func (f *Feed) send(conn *websocket.Conn, message []byte) {
+	if conn == nil {
+		return
+	}
+	if _, err := conn.Write(message); err != nil {
+		fmt.Fprintf(os.Stderr, "Error changing feed subscription: %s\n", err)
+		conn.Close()
+	}
+}
+
+// explicitChannels returns a copy of channels with the products of each listed,
+// rather than left to default to the feed's products
+func explicitChannels(productIDs []string, channels []Channel) []Channel {
+	if len(channels) == 0 {
+		channels = []Channel{{Name: FullChannel}}
+	}
+
+	explicit := make([]Channel, len(channels))
+	for i, c := range channels {
+		explicit[i].Name = c.Name
+		if len(c.ProductIDs) == 0 {
+			explicit[i].ProductIDs = append([]string{}, productIDs...)
+		} else {
+			explicit[i].ProductIDs = append([]string{}, c.ProductIDs...)
+		}
+	}
+	return explicit
+}
+
+// reconnect replaces a broken connection, retrying with exponential backoff
+// until it succeeds. It returns nil if the feed is closed in the meantime
+func (f *Feed) reconnect(broken *websocket.Conn) *websocket.Conn {
+	f.Lock()
+	f.conn = nil
+	f.Unlock()
+	broken.Close()
+
+	backoff := MinReconnectBackoff
 	for {
 		select {
 		case <-f.done:
-			f.done = nil
-			f.Lock()
-			for _, subscriber := range f.subscribers {
-				close(subscriber)
+			return nil
+		case <-time.After(backoff):
+		}
+
+		conn, err := f.dial()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reconnecting to WebSocket: %s\n", err)
+			backoff *= 2
+			if backoff > MaxReconnectBackoff {
+				backoff = MaxReconnectBackoff
 			}
-			f.Unlock()
-			break loop
-
-		default:
-			var message Message
-			if err := d.Decode(&message); err == io.EOF {
-				break loop
-			} else if err != nil {
-				fmt.Fprintln(os.Stderr, "Error reading from WebSocket")
+			continue
+		}
+
+		f.Lock()
+		defer f.Unlock()
+		if f.isClosed() {
+			conn.Close()
+			return nil
+		}
+		f.conn = conn
+		return conn
+	}
+}
+
+func (f *Feed) listen(conn *websocket.Conn) {
+	d := json.NewDecoder(conn)
+	for {
+		var raw json.RawMessage
+		var message Message
+		if err := d.Decode(&raw); err != nil {
+			if f.isClosed() {
+				break
 			}
 
-			f.Lock()
-			for _, subscriber := range f.subscribers {
-				if subscriber == nil {
-					continue
-				}
-				subscriber <- message
+			fmt.Fprintf(os.Stderr, "Error reading from WebSocket: %s\n", err)
+
+			// subscribers are told now rather than once the feed reconnects,
+			// which can take up to MaxReconnectBackoff
+			f.publish(json.RawMessage(`{"type":"disconnected"}`),
+				Disconnected{MessageHeader{Type: DisconnectedMessage}})
+
+			if conn = f.reconnect(conn); conn == nil {
+				break
 			}
+			d = json.NewDecoder(conn)
+			message = Reconnected{MessageHeader{Type: ReconnectedMessage}}
+			raw = json.RawMessage(`{"type":"reconnected"}`)
+		} else if message, err = ParseMessage(raw); err != nil {
+			// books notice the sequence gap it leaves and resynchronize
+			fmt.Fprintf(os.Stderr, "Error reading from WebSocket: %s\n", err)
+		} else {
+			f.Lock()
+			f.messageCounts[message.Header().Type]++
 			f.Unlock()
 		}
+
+		f.publish(raw, message)
+
+		// after delivering, so the answer NewFeed waits for is never sent to
+		// subscribers added once it returns
+		switch message.(type) {
+		case Subscriptions, Error:
+			f.confirm(message)
+		}
+	}
+
+	f.subscribers.end()
+}
+
+// publish records a message and delivers it to subscribers. a message that
+// couldn't be parsed is nil, and only recorded
+func (f *Feed) publish(raw json.RawMessage, message Message) {
+	// disconnections and reconnections are recorded too, so replayed books
+	// reset at the same points the live ones did
+	if recorder := f.getRecorder(); recorder != nil {
+		if err := recorder.RecordMessage(raw); err != nil {
+			fmt.Fprintf(os.Stderr, "Error recording message: %s\n", err)
+		}
+	}
+
+	if message != nil {
+		f.subscribers.deliver(message)
 	}
 }
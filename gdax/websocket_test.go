@@ -0,0 +1,228 @@
+package gdax
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// confirmFull answers a subscription to productIDs on the full channel
+func confirmFull(ws *websocket.Conn, productIDs ...string) error {
+	return websocket.JSON.Send(ws, map[string]interface{}{
+		"type":     SubscriptionsMessage,
+		"channels": []Channel{{Name: FullChannel, ProductIDs: productIDs}},
+	})
+}
+
+func TestFeedReconnects(t *testing.T) {
+	subscribes := make(chan string, 2)
+	drop := make(chan struct{})
+	var connections int32
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var subscribe map[string]interface{}
+		if err := websocket.JSON.Receive(ws, &subscribe); err != nil {
+			return
+		}
+		subscribes <- subscribe["type"].(string)
+
+		if atomic.AddInt32(&connections, 1) == 1 {
+			// drop the first connection once the subscription is confirmed
+			// and the test has subscribed
+			confirmFull(ws, "BTC-USD")
+			<-drop
+			return
+		}
+		websocket.Message.Send(ws, `{"type":"open","sequence":1,"product_id":"BTC-USD",
+			"order_id":"order-a","side":"sell","price":"10000.00","remaining_size":"1"}`)
+		ws.Read(make([]byte, 1)) // block until the client hangs up
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	feed, err := NewFeed(url, "http://localhost", []string{"BTC-USD"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	messages := make(chan Message, 3)
+	feed.Subscribe(messages, Filter{})
+	close(drop)
+
+	for _, expected := range []string{DisconnectedMessage, ReconnectedMessage, OpenMessage} {
+		select {
+		case m := <-messages:
+			if m.Header().Type != expected {
+				t.Errorf("expected %s message, got %s", expected, m.Header().Type)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s message", expected)
+		}
+	}
+
+	if len(subscribes) != 2 {
+		t.Errorf("expected to subscribe twice, subscribed %d times", len(subscribes))
+	}
+
+	feed.Close()
+	if _, ok := <-messages; ok {
+		t.Errorf("subscriber channel should be closed with the feed")
+	}
+}
+
+func TestFeedAddAndRemoveProducts(t *testing.T) {
+	received := make(chan map[string]interface{}, 4)
+	var connections int32
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		first := atomic.AddInt32(&connections, 1) == 1
+		for n := 0; !first || n < 3; n++ {
+			var m map[string]interface{}
+			if err := websocket.JSON.Receive(ws, &m); err != nil {
+				return
+			}
+			received <- m
+			if first && n == 0 {
+				confirmFull(ws, "BTC-USD")
+			}
+		}
+		// drop the first connection once the products have been changed
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	feed, err := NewFeed(url, "http://localhost", []string{"BTC-USD"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer feed.Close()
+
+	if err := feed.AddProduct("ETH-USD", Level2Channel); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := feed.AddProduct("ETH-USD", FullChannel); err == nil {
+		t.Errorf("adding a product twice should fail")
+	}
+	if err := feed.RemoveProduct("BTC-USD"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := feed.RemoveProduct("LTC-USD"); err == nil {
+		t.Errorf("removing a product that isn't subscribed to should fail")
+	}
+
+	for _, expected := range []string{
+		`{"product_ids":["BTC-USD"],"type":"subscribe"}`,
+		`{"channels":[{"name":"level2"}],"product_ids":["ETH-USD"],"type":"subscribe"}`,
+		`{"channels":[{"name":"full"}],"product_ids":["BTC-USD"],"type":"unsubscribe"}`,
+
+		// after reconnecting only what's left is subscribed to
+		`{"channels":[{"name":"level2","product_ids":["ETH-USD"]}],"product_ids":["ETH-USD"],"type":"subscribe"}`,
+	} {
+		select {
+		case m := <-received:
+			if actual, _ := json.Marshal(m); string(actual) != expected {
+				t.Errorf("expected %s, got %s", expected, actual)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+}
+
+func TestNewFeedWaitsForConfirmation(t *testing.T) {
+	defer func(timeout time.Duration) { subscribeTimeout = timeout }(subscribeTimeout)
+	subscribeTimeout = 100 * time.Millisecond
+
+	for _, c := range []struct {
+		name  string
+		reply string
+		check func(error) bool
+	}{
+		{
+			"confirmed",
+			`{"type":"subscriptions","channels":[{"name":"full","product_ids":["BTC-USD","ETH-USD"]}]}`,
+			func(err error) bool { return err == nil },
+		},
+		{
+			"rejected",
+			`{"type":"error","message":"Failed to subscribe","reason":"ETH-USD is not a valid product"}`,
+			func(err error) bool {
+				e, ok := err.(Error)
+				return ok && e.Reason == "ETH-USD is not a valid product"
+			},
+		},
+		{
+			"partly confirmed",
+			`{"type":"subscriptions","channels":[{"name":"full","product_ids":["BTC-USD"]}]}`,
+			func(err error) bool {
+				e, ok := err.(*SubscriptionError)
+				return ok && len(e.Missing) == 1 && strings.Join(e.Missing[FullChannel], ",") == "ETH-USD"
+			},
+		},
+		{
+			"unanswered",
+			"",
+			func(err error) bool { return err == ErrSubscribeTimeout },
+		},
+	} {
+		server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var subscribe map[string]interface{}
+			if err := websocket.JSON.Receive(ws, &subscribe); err != nil {
+				return
+			}
+			if len(c.reply) > 0 {
+				websocket.Message.Send(ws, c.reply)
+			}
+			ws.Read(make([]byte, 1)) // block until the client hangs up
+		}))
+
+		url := "ws" + strings.TrimPrefix(server.URL, "http")
+		feed, err := NewFeed(url, "http://localhost", []string{"BTC-USD", "ETH-USD"})
+		if !c.check(err) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if feed != nil {
+			feed.Close()
+		}
+		server.Close()
+	}
+}
+
+func TestFeedErrorsReachSubscribers(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var subscribe map[string]interface{}
+		if err := websocket.JSON.Receive(ws, &subscribe); err != nil {
+			return
+		}
+		confirmFull(ws, "BTC-USD")
+
+		// an error for a later subscription
+		var m map[string]interface{}
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			return
+		}
+		websocket.Message.Send(ws, `{"type":"error","message":"Failed to subscribe","reason":"XRP-USD is not a valid product"}`)
+		ws.Read(make([]byte, 1)) // block until the client hangs up
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	feed, err := NewFeed(url, "http://localhost", []string{"BTC-USD"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer feed.Close()
+
+	messages := make(chan Message, 1)
+	feed.Subscribe(messages, Filter{ProductIDs: []string{"BTC-USD"}})
+	if err := feed.AddProduct("XRP-USD", FullChannel); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	m, _ := receive(t, messages)
+	if e, ok := m.(Error); !ok || e.Error() != "Failed to subscribe: XRP-USD is not a valid product" {
+		t.Errorf("expected the error, got %+v", m)
+	}
+}